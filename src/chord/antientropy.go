@@ -0,0 +1,288 @@
+// anti-entropy: the owner of a key range compares Merkle trees with its replicas
+// and exchanges only the keys of the buckets which differ
+
+package chord
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+const merkleDepth = 8 // the tree has 2^merkleDepth leaf buckets
+
+// MerkleArgs asks a replica about its copies of the Keys in (Lo, Hi]:
+// the hashes of the nodes Index at depth Level of the Merkle tree, or the Keys of leaf buckets Index
+type MerkleArgs struct {
+	Lo, Hi *big.Int
+	Level  int
+	Index  []int
+}
+
+// merkle tree, tree[d] holds the 2^d hashes at depth d
+type merkleTree [][][]byte
+
+// the buckets and Merkle tree of the copies of DataPre in (lo, hi], kept while DataPre
+// is not written, as a sync asks for a level of the tree at a time
+type merkleCache struct {
+	lock    sync.Mutex
+	lo, hi  *big.Int
+	writes  uint64 // of DataPre when the tree was built
+	buckets []map[string]Siblings
+	tree    merkleTree
+}
+
+// anti-entropy statistics of a node
+type repairStats struct {
+	lock        sync.Mutex
+	last, total int
+}
+
+// method bucketize() splits the Keys of data in (lo, hi] into 2^merkleDepth buckets of equal arcs
+func (o *Node) bucketize(data map[string]Siblings, lo, hi *big.Int) []map[string]Siblings {
+	buckets := make([]map[string]Siblings, 1<<merkleDepth)
+	for i := range buckets {
+		buckets[i] = make(map[string]Siblings)
+	}
+	width := new(big.Int).Sub(hi, lo)
+	width.Mod(width, o.ring)
+	if width.Sign() == 0 {
+		width.Set(o.ring) // the whole ring
+	}
+	for k, v := range data {
+		id := o.hash(k)
+		if !between(lo, id, hi, true) {
+			continue
+		}
+		pos := new(big.Int).Sub(id, lo)
+		pos.Mod(pos, o.ring)
+		pos.Lsh(pos, merkleDepth)
+		pos.Div(pos, width)
+		i := int(pos.Int64())
+		if i >= len(buckets) {
+			i = len(buckets) - 1
+		}
+		buckets[i][k] = v
+	}
+	return buckets
+}
+
+// function buildMerkle() hashes the buckets into a Merkle tree
+func buildMerkle(buckets []map[string]Siblings) merkleTree {
+	tree := make(merkleTree, merkleDepth+1)
+	leaves := make([][]byte, len(buckets))
+	for i, bucket := range buckets {
+		if len(bucket) == 0 {
+			continue
+		}
+		keys := make([]string, 0, len(bucket))
+		for k := range bucket {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		hash := sha1.New()
+		for _, k := range keys {
+			versions := make([]string, len(bucket[k]))
+			for j, v := range bucket[k] {
+				versions[j] = fmt.Sprint(v.Clock, v.Deleted)
+			}
+			sort.Strings(versions)
+			fmt.Fprintln(hash, k, versions)
+		}
+		leaves[i] = hash.Sum(nil)
+	}
+	tree[merkleDepth] = leaves
+	for d := merkleDepth - 1; d >= 0; d-- {
+		level := make([][]byte, 1<<uint(d))
+		for i := range level {
+			left, right := tree[d+1][2*i], tree[d+1][2*i+1]
+			if left == nil && right == nil {
+				continue
+			}
+			hash := sha1.New()
+			hash.Write(left)
+			hash.Write([]byte{0})
+			hash.Write(right)
+			level[i] = hash.Sum(nil)
+		}
+		tree[d] = level
+	}
+	return tree
+}
+
+// method replicaTree() returns the buckets and Merkle tree of the copies in (lo, hi],
+// built again only if DataPre was written or the range changed since the last call
+func (o *Node) replicaTree(lo, hi *big.Int) ([]map[string]Siblings, merkleTree) {
+	c := &o.merkle
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	writes := o.DataPre.written()
+	if c.tree != nil && c.writes == writes && c.lo.Cmp(lo) == 0 && c.hi.Cmp(hi) == 0 {
+		return c.buckets, c.tree
+	}
+	c.lo, c.hi, c.writes = new(big.Int).Set(lo), new(big.Int).Set(hi), writes
+	c.buckets = o.bucketize(o.DataPre.copy(), lo, hi)
+	c.tree = buildMerkle(c.buckets)
+	return c.buckets, c.tree
+}
+
+// method MerkleHashes() returns hashes of the Merkle tree over DataPre
+func (o *Node) MerkleHashes(args MerkleArgs, res *[][]byte) error {
+	_, tree := o.replicaTree(args.Lo, args.Hi)
+	for _, i := range args.Index {
+		*res = append(*res, tree[args.Level][i])
+	}
+	return nil
+}
+
+// method MerkleBuckets() returns the Keys of leaf buckets of DataPre
+func (o *Node) MerkleBuckets(args MerkleArgs, res *map[string]Siblings) error {
+	buckets, _ := o.replicaTree(args.Lo, args.Hi)
+	for _, i := range args.Index {
+		for k, v := range buckets[i] {
+			(*res)[k] = v
+		}
+	}
+	return nil
+}
+
+// method merkleDiff() descends the Merkle trees of the current node and
+// a replica and returns the leaf buckets which differ
+func (o *Node) merkleDiff(addr string, lo, hi *big.Int, tree merkleTree) ([]int, error) {
+	diff := []int{0}
+	for level := 0; ; level++ {
+		var remote [][]byte
+		err := o.transport.Call(addr, "RPCNode.MerkleHashes", MerkleArgs{lo, hi, level, diff}, &remote)
+		if err != nil {
+			return nil, err
+		}
+		var next []int
+		for j, i := range diff {
+			if j < len(remote) && bytes.Equal(tree[level][i], remote[j]) {
+				continue
+			}
+			if level == merkleDepth {
+				next = append(next, i)
+			} else {
+				next = append(next, 2*i, 2*i+1)
+			}
+		}
+		if level == merkleDepth || len(next) == 0 {
+			return next, nil
+		}
+		diff = next
+	}
+}
+
+// method syncBuckets() exchanges the Keys of the differing buckets with a replica,
+// and returns the number of Keys repaired on either side
+func (o *Node) syncBuckets(addr string, lo, hi *big.Int, local []map[string]Siblings, leaves []int) (int, error) {
+	remote := make(map[string]Siblings)
+	err := o.transport.Call(addr, "RPCNode.MerkleBuckets", MerkleArgs{lo, hi, merkleDepth, leaves}, &remote)
+	if err != nil {
+		return 0, err
+	}
+	mine := make(map[string]Siblings)
+	for _, i := range leaves {
+		for k, v := range local[i] {
+			mine[k] = v
+		}
+	}
+
+	toOwner := make(map[string]Siblings)
+	toReplica := make(map[string]Siblings)
+	repaired := 0
+	check := func(k string) {
+		merged := mergeVersions(mine[k], remote[k])
+		stale := false
+		if !sameVersions(merged, mine[k]) {
+			toOwner[k] = merged
+			stale = true
+		}
+		if !sameVersions(merged, remote[k]) {
+			toReplica[k] = merged
+			stale = true
+		}
+		if stale {
+			repaired++
+		}
+	}
+	for k := range mine {
+		check(k)
+	}
+	for k := range remote {
+		if _, ok := mine[k]; !ok {
+			check(k)
+		}
+	}
+
+	err = o.Data.merge(toOwner, o.clock.Now().UnixNano())
+	if err != nil {
+		return repaired, err
+	}
+	if len(toReplica) > 0 {
+		err = o.transport.Call(addr, "RPCNode.ReplicateData", toReplica, new(int))
+	}
+	return repaired, err
+}
+
+// method antiEntropyRound() syncs the keys of the current node with every replica once
+// and returns the number of Keys repaired
+func (o *Node) antiEntropyRound() int {
+	pred := o.Predecessor
+	if pred == nil || o.Replicas < 2 {
+		return 0
+	}
+	lo, hi := new(big.Int).Set(pred.ID), new(big.Int).Set(o.ID)
+	local := o.bucketize(o.Data.copy(), lo, hi)
+	tree := buildMerkle(local)
+
+	repaired := 0
+	for _, addr := range o.replicaSet() {
+		leaves, err := o.merkleDiff(addr, lo, hi, tree)
+		if err != nil {
+			o.logger("anti_entropy").Warn("merkle diff failed", "peer", addr, "err", err)
+			continue
+		}
+		if len(leaves) == 0 {
+			continue
+		}
+		n, err := o.syncBuckets(addr, lo, hi, local, leaves)
+		if err != nil {
+			o.logger("anti_entropy").Warn("sync buckets failed", "peer", addr, "err", err)
+		}
+		repaired += n
+	}
+	return repaired
+}
+
+// method AntiEntropy() runs a round of anti-entropy every AntiEntropyInterval of the config
+// and reports the Keys repaired, then drops the copies the node is no longer a replica of
+func (o *Node) AntiEntropy() {
+	for o.ON == true {
+		o.clock.Sleep(time.Duration(o.cfg.AntiEntropyInterval))
+		n := o.antiEntropyRound()
+		if dropped := o.dropStrayCopies(); dropped > 0 {
+			o.logger("replicate").Info("dropped stray copies", "keys", dropped)
+		}
+
+		o.repaired.lock.Lock()
+		o.repaired.last = n
+		o.repaired.total += n
+		o.repaired.lock.Unlock()
+		if n > 0 {
+			o.logger("anti_entropy").Info("repaired keys", "keys", n)
+		}
+	}
+}
+
+// method Repaired() returns the Keys repaired by the last round of anti-entropy and in total
+func (o *Node) Repaired() (last, total int) {
+	o.repaired.lock.Lock()
+	defer o.repaired.lock.Unlock()
+	return o.repaired.last, o.repaired.total
+}
@@ -4,114 +4,62 @@ package chord
 
 import (
 	"errors"
-	"fmt"
 	"math/big"
-	"time"
 )
 
 // method Ping() ping the given address
 func (o *Node) Ping(addr string) bool {
-	return Ping(addr)
+	return o.transport.Ping(addr)
 }
 
-// method PutValue() puts a Value into the map
-func (o *Node) PutValue(kv KVPair, success *bool) error {
-	o.Data.lock.Lock()
-	o.Data.Map[kv.Key] = kv.Value
-	o.Data.lock.Unlock()
-	*success = true
-	return nil
+// WriteReply is the reply of a write which creates a new version of a Key
+type WriteReply struct {
+	Version Versioned
+	Found   bool   // whether a value was replaced or deleted
+	Applied bool   // false if the condition of a conditional write failed
+	Err     string // why a Key of a batch was not written, empty if it was
 }
 
-// method GetValue() returns Value of a Key
-func (o *Node) GetValue(key string, value *string) error {
-	o.Data.lock.Lock()
-	str, ok := o.Data.Map[key]
-	o.Data.lock.Unlock()
-	*value = str
-	if ok == false {
-		return errors.New("Get not found ")
+// method err() returns the error of a Key of a batch, nil if it was written
+func (r WriteReply) err() error {
+	if r.Err == "" {
+		return nil
 	}
-	return nil
+	return remoteError(errors.New(r.Err))
 }
 
-// method DeleteValue() deletes a Value
-func (o *Node) DeleteValue(key string, success *bool) error {
-	o.Data.lock.Lock()
-	_, ok := o.Data.Map[key]
-	if ok == true {
-		delete(o.Data.Map, key)
-		*success = true
-	} else {
-		*success = false
-	}
-	o.Data.lock.Unlock()
-	return nil
+// method PutValue() puts a new version of a Value into the map
+func (o *Node) PutValue(kv KVPair, res *WriteReply) error {
+	var err error
+	res.Version, res.Found, err = o.Data.mint(kv.Key, kv.Value, false, o.Addr, o.clock.Now().UnixNano(), kv.Expire)
+	res.Applied = err == nil
+	return err
 }
 
-// method PutValueSuccessor() put value to the successor's DataPre
-func (o *Node) PutValueSuccessor(kv KVPair, success *bool) error {
-	err := o.FixSuccessors()
-	if err != nil {
-		return err
-	}
-	if Ping(o.Successor[1].Addr) == false {
-		return errors.New("Error: Not connected[6] ")
-	}
-	client, err := Dial(o.Successor[1].Addr)
-	if err != nil {
-		return err
-	}
-	err = client.Call("RPCNode.PutValueDataPre", kv, success)
-	if err != nil {
-		_ = client.Close()
-		return err
-	}
-	err = client.Close()
-	if err != nil {
-		return err
-	}
-	*success = true
+// method GetValue() returns the versions of a Key, tombstones included, expired ones as tombstones
+func (o *Node) GetValue(key string, res *Siblings) error {
+	*res = o.Data.get(key).expire(o.clock.Now().UnixNano())
 	return nil
 }
 
-func (o *Node) DeleteValueSuccessor(key string, success *bool) error {
-	err := o.FixSuccessors()
-	if err != nil {
-		return err
-	}
-	if Ping(o.Successor[1].Addr) == false {
-		return errors.New("Error: Not connected[7] ")
-	}
-	client, err := Dial(o.Successor[1].Addr)
-	if err != nil {
-		return err
-	}
-	err = client.Call("RPCNode.DeleteValueDataPre", key, success)
-	if err != nil {
-		_ = client.Close()
-		return err
-	}
-	err = client.Close()
-	if err != nil {
-		return err
-	}
-	return nil
+// method DeleteValue() replaces a Value with a tombstone
+func (o *Node) DeleteValue(key string, res *WriteReply) error {
+	var err error
+	res.Version, res.Found, err = o.Data.mint(key, nil, true, o.Addr, o.clock.Now().UnixNano(), 0)
+	res.Applied = err == nil
+	return err
 }
 
-func (o *Node) PutValueDataPre(kv KVPair, success *bool) error {
-	o.DataPre.lock.Lock()
-	o.DataPre.Map[kv.Key] = kv.Value
-	o.DataPre.lock.Unlock()
-	*success = true
-	return nil
+// method MergeData() merges versions of Keys into the map
+func (o *Node) MergeData(data map[string]Siblings, res *int) error {
+	err := o.Data.merge(data, o.clock.Now().UnixNano())
+	*res = len(data)
+	return err
 }
 
-func (o *Node) DeleteValueDataPre(key string, success *bool) error {
-	o.DataPre.lock.Lock()
-	delete(o.DataPre.Map, key)
-	o.DataPre.lock.Unlock()
-	*success = true
+// method GetValueDataPre() returns the versions of a Key kept for a predecessor, see GetValue()
+func (o *Node) GetValueDataPre(key string, res *Siblings) error {
+	*res = o.DataPre.get(key).expire(o.clock.Now().UnixNano())
 	return nil
 }
 
@@ -125,166 +73,85 @@ func (o *Node) GetPredecessor(args int, res *Edge) error {
 }
 
 // method GetSuccessorList() returns a list of successors of a node
-func (o *Node) GetSuccessorList(args int, res *[successorListLen + 1]Edge) error {
+func (o *Node) GetSuccessorList(args int, res *[]Edge) error {
 	o.sLock.Lock()
-	for i := 1; i <= successorListLen; i++ {
+	*res = make([]Edge, len(o.Successor))
+	for i := 1; i < len(o.Successor); i++ {
 		(*res)[i] = Edge{o.Successor[i].Addr, new(big.Int).Set(o.Successor[i].ID)}
 	}
 	o.sLock.Unlock()
 	return nil
 }
 
-// method MoveAllDataToSuccessor(successor) moves the data of the current node to its successor
-func (o *Node) MoveAllDataToSuccessor() {
-	if Ping(o.Successor[1].Addr) == false {
-		fmt.Println("Error: Not connected[1]")
-		return
-	}
-	client, err := Dial(o.Successor[1].Addr)
-	if err != nil {
-		fmt.Println("Error: Dialing error[1]: ", err)
-		return
-	}
-
-	err = client.Call("RPCNode.QuitMoveData", o.Data, new(int))
-	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Calling Node.QuitMoveData: ", err)
-		return
-	}
-	err = client.Call("RPCNode.QuitMoveDataPre", o.DataPre, new(int))
-	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Calling Node.QuitMoveDataPre: ", err)
-		return
-	}
-	err = client.Close()
-	if err != nil {
-		fmt.Println("Error: Close client error: ", err)
-		return
-	}
+// MoveArgs asks for the data of a joining node. Have holds the versions (VClock strings)
+// of the Keys the joining node already keeps, e.g. from before a restart, which are not sent
+type MoveArgs struct {
+	ID   *big.Int
+	Have map[string]string
 }
 
 // method MoveKVPairs() called when Join(), move successor's data to my data
-func (o *Node) MoveKVPairs(newNode *big.Int, res *map[string]string) error {
+func (o *Node) MoveKVPairs(args MoveArgs, res *map[string]Siblings) error {
 	cnt := 0
-	for o.Predecessor == nil && cnt < FailTimes {
-		time.Sleep(Second)
+	for o.Predecessor == nil && cnt < o.cfg.FailTimes {
+		o.clock.Sleep(Second)
 		cnt++
 	}
-	if cnt == FailTimes {
+	if cnt == o.cfg.FailTimes {
 		return errors.New("Predecessor not found when Join ")
 	}
 	o.DataPre.lock.Lock()
 	o.Data.lock.Lock()
-	o.DataPre.Map = make(map[string]string)
-	for k, v := range o.Data.Map {
-		KID := hashString(k)
-		if between(o.Predecessor.ID, KID, newNode, true) {
-			(*res)[k] = v
-			o.DataPre.Map[k] = v
+	moved := make(map[string]Siblings)
+	o.Data.store.Range(func(k string, v Siblings) {
+		if between(o.Predecessor.ID, o.hash(k), args.ID, true) {
+			moved[k] = v
 		}
-	}
-	for k := range *res {
-		delete(o.Data.Map, k)
-	}
-	o.Data.lock.Unlock()
-	o.DataPre.lock.Unlock()
-	return nil
-}
-
-// method MoveDataPre() called when Join(), move successor's DataPre to my Data
-func (o *Node) MoveDataPre(args int, res *map[string]string) error {
-	if args == 0 {
-		o.DataPre.lock.Lock()
-		for k, v := range o.DataPre.Map {
+	})
+	for k, v := range moved {
+		if args.Have[k] != v.clock().String() {
 			(*res)[k] = v
 		}
-		o.DataPre.lock.Unlock()
-	} else {
-		o.Data.lock.Lock()
-		for k, v := range o.Data.Map {
-			(*res)[k] = v
+		// the keys go to the joining node whatever the storage says, failing here would lose them
+		if o.Replicas > 1 {
+			err := o.DataPre.set(k, mergeVersions(o.DataPre.store.Get(k), v))
+			if err != nil {
+				o.logger("join").Error("keep replica failed", "err", err)
+			}
 		}
-		o.Data.lock.Unlock()
-	}
-	return nil
-}
-
-// method QuitMoveData()
-func (o *Node) QuitMoveData(Data KVMap, res *int) error {
-	err := o.FixSuccessors()
-	if err != nil {
-		return err
-	}
-	if !Ping(o.Successor[1].Addr) {
-		return errors.New("Error: Not connected[8] ")
-	}
-	client, err := Dial(o.Successor[1].Addr)
-	if err != nil {
-		return err
-	}
-	Data.lock.Lock()
-	o.Data.lock.Lock()
-	for k, v := range Data.Map {
-		o.Data.Map[k] = v
-		err = client.Call("RPCNode.PutValueDataPre", KVPair{k, v}, new(bool))
+		err := o.Data.remove(k)
 		if err != nil {
-			o.Data.lock.Unlock()
-			Data.lock.Unlock()
-			_ = client.Close()
-			return err
+			o.logger("join").Error("drop moved key failed", "err", err)
 		}
 	}
 	o.Data.lock.Unlock()
-	Data.lock.Unlock()
-
-	err = client.Close()
-	if err != nil {
-		return err
-	}
+	o.DataPre.lock.Unlock()
 	return nil
 }
 
-// method QuitMoveDataPre()
-func (o *Node) QuitMoveDataPre(DataPre KVMap, res *int) error {
-	o.DataPre.lock.Lock()
-	//o.DataPre.Map = make(map[string]string)
-	o.DataPre.Map = DataPre.Map
-	o.DataPre.lock.Unlock()
+// method MoveDataPre() called when Join(), move successor's DataPre to my DataPre
+func (o *Node) MoveDataPre(args MoveArgs, res *map[string]Siblings) error {
+	for k, v := range o.DataPre.copy() {
+		if args.Have[k] != v.clock().String() {
+			(*res)[k] = v
+		}
+	}
 	return nil
 }
 
 // method SetSuccessor()
 func (o *Node) SetSuccessor(edge Edge, res *int) error {
 	o.Successor[1] = edge
-	var list [successorListLen + 1]Edge
+	var list []Edge
 
-	if Ping(o.Successor[1].Addr) == false {
-		fmt.Println("Error: Not connected[1]")
-		return errors.New("Not connected[1] ")
-	}
-	client, err := Dial(o.Successor[1].Addr)
-	if err != nil {
-		fmt.Println("Error: Dialing error[4]: ", err)
-		return err
-	}
-	err = client.Call("RPCNode.GetSuccessorList", 0, &list)
-	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Call GetSuccessorList Error", err)
-		return err
-	}
-	err = client.Close()
+	err := o.transport.Call(o.Successor[1].Addr, "RPCNode.GetSuccessorList", 0, &list)
 	if err != nil {
-		fmt.Println("Error: Close client error: ", err)
+		o.logger("set_successor").Warn("get successor list failed", "peer", o.Successor[1].Addr, "err", err)
 		return err
 	}
 
 	o.sLock.Lock()
-	for i := 2; i <= successorListLen; i++ {
-		o.Successor[i] = list[i-1]
-	}
+	copy(o.Successor[2:], list[1:])
 	o.sLock.Unlock()
 	return nil
 }
@@ -312,81 +179,38 @@ func (o *Node) SetPredecessor(edge Edge, res *int) error {
 
 // method simpleStabilize() stabilize once
 func (o *Node) simpleStabilize() {
+	o.metrics.stabilize.Inc()
 	err := o.FixSuccessors()
 	if err != nil {
 		return
 	}
-	oldSuccessor := o.Successor[1]
-
-	if Ping(o.Successor[1].Addr) == false {
-		//fmt.Println("Error: Not connected[2]")
-		return
-	}
-	client, err := Dial(o.Successor[1].Addr)
-	if err != nil {
-		//fmt.Println("Error: Dialing error[2]: ", err)
-		return
-	}
-
-	defer func() {
-		err = client.Call("RPCNode.Notify", &Edge{o.Addr, new(big.Int).Set(o.ID)}, new(int))
-		if err != nil {
-			_ = client.Close()
-			fmt.Println("Error: Node.Notify error: ", err)
-			return
-		}
 
-		var list [successorListLen + 1]Edge
-		err = client.Call("RPCNode.GetSuccessorList", 0, &list)
-		if err != nil {
-			_ = client.Close()
-			fmt.Println("Error: Call GetSuccessorList Error", err)
-			return
-		}
+	var successorPre Edge
+	err = o.transport.Call(o.Successor[1].Addr, "RPCNode.GetPredecessor", 0, &successorPre)
+	if err == nil && o.Ping(successorPre.Addr) &&
+		between(o.ID, successorPre.ID, o.Successor[1].ID, false) {
 		o.sLock.Lock()
-		for i := 2; i <= successorListLen; i++ {
-			o.Successor[i] = list[i-1]
-		}
+		o.Successor[1] = successorPre
 		o.sLock.Unlock()
+	}
 
-		err = client.Close()
-		if err != nil {
-			fmt.Println("Error: Close client error: ", err)
-			return
-		}
-	}()
-
-	var successorPre Edge
-	err = client.Call("RPCNode.GetPredecessor", 0, &successorPre)
+	err = o.transport.Call(o.Successor[1].Addr, "RPCNode.Notify", &Edge{o.Addr, new(big.Int).Set(o.ID)}, new(int))
 	if err != nil {
-		//fmt.Println("Error: Calling Node.GetPredecessor: ", err, o.Addr, "successor", o.Successor[1].Addr)
+		o.logger("stabilize").Debug("notify failed", "peer", o.Successor[1].Addr, "err", err)
 		return
 	}
-	if !Ping(successorPre.Addr) {
+
+	var list []Edge
+	err = o.transport.Call(o.Successor[1].Addr, "RPCNode.GetSuccessorList", 0, &list)
+	if err != nil {
+		o.logger("stabilize").Debug("get successor list failed", "peer", o.Successor[1].Addr, "err", err)
 		return
 	}
+	o.sLock.Lock()
+	copy(o.Successor[2:], list[1:])
+	o.sLock.Unlock()
 
-	if between(o.ID, successorPre.ID, o.Successor[1].ID, false) {
-		o.sLock.Lock()
-		o.Successor[1] = successorPre
-		err = client.Close()
-		if err != nil {
-			o.sLock.Unlock()
-			fmt.Println("Error: Close client error: ", err)
-			return
-		}
-
-		if Ping(o.Successor[1].Addr) == false {
-			fmt.Println("Error: Not connected[3]", oldSuccessor)
-			return
-		}
-		client, err = Dial(o.Successor[1].Addr)
-		o.sLock.Unlock()
-		if err != nil {
-			fmt.Println("Error: Dialing error[3]: ", err, o.Addr, "successorPre", successorPre.Addr)
-			return
-		}
-	}
+	o.checkReplicas()
 }
 
 // method FixSuccessors fixes the successor list
@@ -397,14 +221,14 @@ func (o *Node) FixSuccessors() error {
 	o.sLock.Lock()
 
 	var p int
-	for p = 1; p <= successorListLen; p++ {
+	for p = 1; p <= o.cfg.SuccessorListLen; p++ {
 		if o.Ping(o.Successor[p].Addr) {
 			break
 		}
 	}
-	if p == successorListLen+1 {
+	if p == o.cfg.SuccessorListLen+1 {
 		o.sLock.Unlock()
-		return errors.New("Error: No valid successor!!!! ")
+		return ErrNoSuccessor
 	}
 
 	if p == 1 {
@@ -414,33 +238,16 @@ func (o *Node) FixSuccessors() error {
 
 	o.Successor[1] = o.Successor[p]
 	o.sLock.Unlock()
-	var list [successorListLen + 1]Edge
-	if Ping(o.Successor[1].Addr) == false {
-		fmt.Println("Error: Not connected[4]")
-		return nil
-	}
-	client, err := Dial(o.Successor[1].Addr)
+	o.metrics.dropped.Add(float64(p - 1))
+	var list []Edge
+	err := o.transport.Call(o.Successor[1].Addr, "RPCNode.GetSuccessorList", 0, &list)
 	if err != nil {
-		fmt.Println("Error: Dialing error[4]: ", err)
-		return nil
-	}
-
-	err = client.Call("RPCNode.GetSuccessorList", 0, &list)
-	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Call GetSuccessorList Error", err)
-		return nil
-	}
-	err = client.Close()
-	if err != nil {
-		fmt.Println("Error: Close client error: ", err)
+		o.logger("fix_successors").Debug("get successor list failed", "peer", o.Successor[1].Addr, "err", err)
 		return nil
 	}
 
 	o.sLock.Lock()
-	for i := 2; i <= successorListLen; i++ {
-		o.Successor[i] = list[i-1]
-	}
+	copy(o.Successor[2:], list[1:])
 	o.sLock.Unlock()
 	return nil
 }
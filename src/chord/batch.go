@@ -0,0 +1,428 @@
+// batches of puts, gets and deletes, grouped by the owner of each Key
+
+package chord
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"trace"
+)
+
+// batchWorkers bounds the lookups or groups of a batch in flight at a time
+const batchWorkers = 16
+
+// BatchResult is the outcome of one Key of a batch, Value being set by the gets only
+type BatchResult struct {
+	Key   string
+	Value []byte
+	Err   error
+}
+
+// the Keys of a batch which have the same owner, and so the same replicas
+type batchGroup struct {
+	targets []string // the owner first
+	indexes []int    // of the Keys in the batch
+}
+
+// method parallel() runs f(0), ..., f(n-1) in goroutines of the clock and waits for them.
+// It polls instead of blocking, as a goroutine of a simulated clock may only wait in Sleep
+func (o *Node) parallel(n int, f func(i int)) {
+	var lock sync.Mutex
+	next, done := 0, 0
+	for w := 0; w < min(n, batchWorkers); w++ {
+		o.clock.Go(func() {
+			for {
+				lock.Lock()
+				i := next
+				next++
+				lock.Unlock()
+				if i >= n {
+					break
+				}
+				f(i)
+				lock.Lock()
+				done++
+				lock.Unlock()
+			}
+		})
+	}
+	for {
+		lock.Lock()
+		finished := done == n
+		lock.Unlock()
+		if finished {
+			return
+		}
+		o.clock.Sleep(time.Millisecond)
+	}
+}
+
+// method groupByOwner() looks up the owners of keys in parallel and groups the Keys by owner.
+// The Err of a Key whose owner was not found is set in res, and the Key left out
+func (o *Node) groupByOwner(ctx context.Context, keys []string, res []BatchResult) []*batchGroup {
+	targets := make([][]string, len(keys))
+	o.parallel(len(keys), func(i int) {
+		_, targets[i], res[i].Err = o.lookupReplicas(ctx, keys[i])
+	})
+	byOwner := make(map[string]*batchGroup)
+	var groups []*batchGroup
+	for i := range keys {
+		if res[i].Err != nil {
+			continue
+		}
+		g, ok := byOwner[targets[i][0]]
+		if ok == false {
+			g = &batchGroup{targets: targets[i]}
+			byOwner[targets[i][0]] = g
+			groups = append(groups, g)
+		}
+		g.indexes = append(g.indexes, i)
+	}
+	return groups
+}
+
+// function newResults() returns the results of a batch of keys, none failed yet
+func newResults(keys []string) []BatchResult {
+	res := make([]BatchResult, len(keys))
+	for i, key := range keys {
+		res[i].Key = key
+	}
+	return res
+}
+
+// function batchError() returns the first error of a batch, for its metrics
+func batchError(res []BatchResult) error {
+	for _, r := range res {
+		if r.Err != nil && errors.Is(r.Err, ErrNotFound) == false {
+			return r.Err
+		}
+	}
+	return nil
+}
+
+// method writeBatch() writes a new version of each of keys to targets until level is met,
+// as writeVersion() does for one Key: the owner creates the versions in one call of method
+// with args, and those it created are then merged into each other replica in one call.
+// The reply of a Key the owner failed to write has its Err set
+func (o *Node) writeBatch(ctx context.Context, keys []string, targets []string, level Consistency, method string, args interface{}) ([]WriteReply, error) {
+	var replies []WriteReply
+	var created map[string]Siblings
+	err := o.writeReplicas(ctx, targets, level, func(ctx context.Context, addr string, isOwner bool) error {
+		if isOwner == false {
+			if len(created) == 0 {
+				return nil // nothing to forward
+			}
+			return o.storeVersions(ctx, addr, false, created)
+		}
+		err := o.call(ctx, addr, method, args, &replies)
+		if err != nil {
+			return err
+		}
+		if len(replies) != len(keys) {
+			return errors.New("Batch reply of a wrong length ")
+		}
+		created = make(map[string]Siblings, len(keys))
+		for i, key := range keys { // a Key given twice keeps its later version
+			if replies[i].Err == "" {
+				created[key] = Siblings{replies[i].Version}
+			}
+		}
+		return nil
+	})
+	return replies, err
+}
+
+// method writeGroups() writes the Keys of a batch to their owners, write writing the Keys of
+// indexes (into keys) to targets, the owner first, and setting their results in res.
+// As retryOwner() does for one Key, the Keys whose owner was unreachable are looked up
+// and written again a few times
+func (o *Node) writeGroups(ctx context.Context, keys []string, res []BatchResult, write func(targets []string, indexes []int)) {
+	pending := make([]int, len(keys))
+	for i := range keys {
+		pending[i] = i
+	}
+	for round := 0; round < 5 && len(pending) > 0; round++ {
+		if round > 0 {
+			trace.From(ctx).Retry()
+			if err := o.sleepContext(ctx, 200*time.Millisecond); err != nil {
+				for _, i := range pending {
+					res[i].Err = err
+				}
+				return
+			}
+		}
+		roundKeys := make([]string, len(pending))
+		for j, i := range pending {
+			roundKeys[j] = keys[i]
+		}
+		roundRes := newResults(roundKeys)
+		groups := o.groupByOwner(ctx, roundKeys, roundRes)
+		for j, i := range pending {
+			res[i].Err = roundRes[j].Err
+		}
+		o.parallel(len(groups), func(g int) {
+			indexes := make([]int, len(groups[g].indexes))
+			for j, r := range groups[g].indexes {
+				indexes[j] = pending[r]
+			}
+			write(groups[g].targets, indexes)
+		})
+
+		var failed []int
+		for _, i := range pending {
+			if errors.Is(res[i].Err, ErrOwnerUnreachable) {
+				failed = append(failed, i)
+			}
+		}
+		pending = failed
+	}
+}
+
+// result of reading a batch from one replica
+type batchRead struct {
+	addr     string
+	isOwner  bool
+	versions []Siblings
+}
+
+// method readBatch() reads the versions of keys from targets until level is met, as
+// readReplicas() does for one Key, in one call per replica
+func (o *Node) readBatch(ctx context.Context, keys []string, targets []string, level Consistency) ([]Siblings, error) {
+	need := level.required(o.Replicas)
+	var results []batchRead
+	var lastErr error
+	for i, addr := range targets {
+		if len(results) >= need {
+			break
+		}
+		if err := o.checkContext(ctx); err != nil {
+			return nil, err
+		}
+		method := "RPCNode.GetValuesDataPre"
+		if i == 0 {
+			method = "RPCNode.GetValues"
+		}
+		var versions []Siblings
+		err := o.call(ctx, addr, method, keys, &versions)
+		if err == nil && len(versions) != len(keys) {
+			err = errors.New("Batch reply of a wrong length ")
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		results = append(results, batchRead{addr, i == 0, versions})
+	}
+	if len(results) < need {
+		return nil, tooFewReplicas(level, len(results), need, lastErr)
+	}
+
+	merged := make([]Siblings, len(keys))
+	for i := range keys {
+		for _, r := range results {
+			merged[i] = mergeVersions(merged[i], r.versions[i])
+		}
+	}
+	for _, r := range results {
+		stale := make(map[string]Siblings)
+		for i, key := range keys {
+			if !sameVersions(r.versions[i], merged[i]) {
+				stale[key] = merged[i]
+			}
+		}
+		if len(stale) > 0 {
+			o.clock.Go(func() {
+				err := o.storeVersions(context.Background(), r.addr, r.isOwner, stale)
+				if err != nil {
+					o.logger("read_repair").Warn("read repair failed", "peer", r.addr, "err", err)
+				}
+			})
+		}
+	}
+	return merged, nil
+}
+
+// put many Keys into the chord ring, see MultiPutLevel()
+func (o *Node) MultiPut(ctx context.Context, pairs []KVPair) []BatchResult {
+	return o.MultiPutLevel(ctx, pairs, o.Consistency)
+}
+
+// put many Keys into the chord ring, waiting for as many replicas as level requires.
+// The owners of the Keys are looked up in parallel, and the Keys of each owner are
+// written to it and to each of its replicas in one call. As Put() does, the Keys whose owner
+// was unreachable are tried again a few times. The results are in the order of pairs
+func (o *Node) MultiPutLevel(ctx context.Context, pairs []KVPair, level Consistency) (res []BatchResult) {
+	keys := make([]string, len(pairs))
+	for i, kv := range pairs {
+		keys[i] = kv.Key
+	}
+	defer func(start time.Time) { o.observe("multi_put", start, batchError(res)) }(o.clock.Now())
+	o.clock.Sleep(15 * time.Millisecond)
+
+	res = newResults(keys)
+	o.writeGroups(ctx, keys, res, func(targets []string, indexes []int) {
+		group := make([]KVPair, len(indexes))
+		groupKeys := make([]string, len(indexes))
+		for j, i := range indexes {
+			group[j], groupKeys[j] = pairs[i], keys[i]
+		}
+		replies, err := o.writeBatch(ctx, groupKeys, targets, level, "RPCNode.PutValues", group)
+		for j, i := range indexes {
+			res[i].Err = err
+			if err == nil {
+				res[i].Err = replies[j].err()
+			}
+		}
+	})
+	return res
+}
+
+// get many Keys, see MultiGetLevel()
+func (o *Node) MultiGet(ctx context.Context, keys []string) []BatchResult {
+	return o.MultiGetLevel(ctx, keys, o.Consistency)
+}
+
+// get many Keys, reading as many replicas as level requires, ErrNotFound for a Key which
+// does not exist. The Keys of each owner are read from it and from its replicas in one call.
+// As Get() does, the Keys which were not read are tried again a few times
+func (o *Node) MultiGetLevel(ctx context.Context, keys []string, level Consistency) (res []BatchResult) {
+	defer func(start time.Time) { o.observe("multi_get", start, batchError(res)) }(o.clock.Now())
+	o.clock.Sleep(15 * time.Millisecond)
+
+	res = newResults(keys)
+	pending := make([]int, len(keys))
+	for i := range keys {
+		pending[i] = i
+	}
+	for round := 0; round < 5 && len(pending) > 0; round++ {
+		if round > 0 {
+			trace.From(ctx).Retry()
+			err := o.sleepContext(ctx, 200*time.Millisecond)
+			if err != nil {
+				for _, i := range pending {
+					res[i].Err = err
+				}
+				break
+			}
+		}
+		roundKeys := make([]string, len(pending))
+		for j, i := range pending {
+			roundKeys[j] = keys[i]
+		}
+		roundRes := newResults(roundKeys)
+		groups := o.groupByOwner(ctx, roundKeys, roundRes)
+		o.parallel(len(groups), func(g int) {
+			groupKeys := make([]string, len(groups[g].indexes))
+			for j, i := range groups[g].indexes {
+				groupKeys[j] = roundKeys[i]
+			}
+			versions, err := o.readBatch(ctx, groupKeys, groups[g].targets, level)
+			for j, i := range groups[g].indexes {
+				switch {
+				case err != nil:
+					roundRes[i].Err = err
+				case len(versions[j].Live()) == 0:
+					roundRes[i].Err = ErrNotFound
+				default:
+					value, found := versions[j].Resolve()
+					if found == false { // a concurrent delete wins
+						roundRes[i].Err = ErrNotFound
+					} else {
+						roundRes[i].Value = value
+					}
+				}
+			}
+		})
+
+		var failed []int
+		for j, i := range pending {
+			res[i] = roundRes[j]
+			if roundRes[j].Err != nil {
+				failed = append(failed, i)
+			}
+		}
+		pending = failed
+	}
+	return res
+}
+
+// delete many Keys, see MultiDeleteLevel()
+func (o *Node) MultiDelete(ctx context.Context, keys []string) []BatchResult {
+	return o.MultiDeleteLevel(ctx, keys, o.Consistency)
+}
+
+// delete many Keys, waiting for as many replicas as level requires, ErrNotFound for a Key
+// which does not exist. The Keys of each owner are deleted at it and at each of its replicas in one call,
+// and tried again a few times while their owner is unreachable, as Delete() does
+func (o *Node) MultiDeleteLevel(ctx context.Context, keys []string, level Consistency) (res []BatchResult) {
+	defer func(start time.Time) { o.observe("multi_delete", start, batchError(res)) }(o.clock.Now())
+	o.clock.Sleep(15 * time.Millisecond)
+
+	res = newResults(keys)
+	o.writeGroups(ctx, keys, res, func(targets []string, indexes []int) {
+		groupKeys := make([]string, len(indexes))
+		for j, i := range indexes {
+			groupKeys[j] = keys[i]
+		}
+		replies, err := o.writeBatch(ctx, groupKeys, targets, level, "RPCNode.DeleteValues", groupKeys)
+		for j, i := range indexes {
+			switch {
+			case err != nil:
+				res[i].Err = err
+			case replies[j].Err != "":
+				res[i].Err = replies[j].err()
+			case replies[j].Found == false:
+				res[i].Err = ErrNotFound
+			default:
+				res[i].Err = nil
+			}
+		}
+	})
+	return res
+}
+
+// method PutValues() puts a new version of each pair into the map, in order,
+// the reply of a pair which failed having its Err set
+func (o *Node) PutValues(pairs []KVPair, res *[]WriteReply) error {
+	*res = make([]WriteReply, len(pairs))
+	for i, kv := range pairs {
+		err := o.PutValue(kv, &(*res)[i])
+		if err != nil {
+			(*res)[i].Err = err.Error()
+		}
+	}
+	return nil
+}
+
+// method GetValues() returns the versions of each Key, see GetValue()
+func (o *Node) GetValues(keys []string, res *[]Siblings) error {
+	*res = make([]Siblings, len(keys))
+	for i, key := range keys {
+		o.GetValue(key, &(*res)[i])
+	}
+	return nil
+}
+
+// method DeleteValues() replaces each Value with a tombstone, in order,
+// the reply of a Key which failed having its Err set
+func (o *Node) DeleteValues(keys []string, res *[]WriteReply) error {
+	*res = make([]WriteReply, len(keys))
+	for i, key := range keys {
+		err := o.DeleteValue(key, &(*res)[i])
+		if err != nil {
+			(*res)[i].Err = err.Error()
+		}
+	}
+	return nil
+}
+
+// method GetValuesDataPre() is GetValues() from DataPre
+func (o *Node) GetValuesDataPre(keys []string, res *[]Siblings) error {
+	*res = make([]Siblings, len(keys))
+	for i, key := range keys {
+		o.GetValueDataPre(key, &(*res)[i])
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// conditional writes, applied atomically at the owner of a Key
+
+package chord
+
+import (
+	"context"
+	"time"
+)
+
+// CondWrite is a write which the owner applies only if the Key is at version Expected.
+// A version is the String() of the clock of a Key's versions, as returned by GetWithVersion().
+// Expected == "" means the Key must be absent
+type CondWrite struct {
+	Key      string
+	Value    []byte
+	Deleted  bool
+	Expected string
+	Expire   int64 // of the new version, see Versioned
+}
+
+// method holds() checks the condition against the current versions of the Key
+func (cw CondWrite) holds(versions Siblings) bool {
+	if cw.Expected == "" {
+		return len(versions.Live()) == 0
+	}
+	return len(versions.Live()) > 0 && versions.clock().String() == cw.Expected
+}
+
+// method CondWriteValue() applies a conditional write to the map
+func (o *Node) CondWriteValue(cw CondWrite, res *WriteReply) error {
+	var err error
+	res.Version, res.Found, res.Applied, err = o.Data.mintIf(cw.Key, cw.Value, cw.Deleted, o.Addr, o.clock.Now().UnixNano(), cw.Expire, cw.holds)
+	return err
+}
+
+// method condWrite() applies cw at the owner and forwards the new version to the replicas.
+// It returns ErrConditionFailed if the condition does not hold at the owner
+func (o *Node) condWrite(ctx context.Context, cw CondWrite, level Consistency) (err error) {
+	defer func(start time.Time) { o.observe("cas", start, err) }(o.clock.Now())
+	o.clock.Sleep(15 * time.Millisecond)
+
+	_, targets, err := o.lookupReplicas(ctx, cw.Key)
+	if err != nil {
+		return err
+	}
+	var reply WriteReply
+	err = o.writeReplicas(ctx, targets, level, func(ctx context.Context, addr string, isOwner bool) error {
+		if isOwner {
+			return o.call(ctx, addr, "RPCNode.CondWriteValue", cw, &reply)
+		}
+		if reply.Applied == false {
+			return nil // nothing to forward
+		}
+		return o.storeVersions(ctx, addr, false, map[string]Siblings{cw.Key: {reply.Version}})
+	})
+	if err != nil {
+		return err
+	}
+	if reply.Applied == false {
+		return ErrConditionFailed
+	}
+	return nil
+}
+
+// put a Key only if it does not exist
+func (o *Node) PutIfAbsent(ctx context.Context, key string, value []byte) error {
+	return o.condWrite(ctx, CondWrite{Key: key, Value: value}, o.Consistency)
+}
+
+// put a Key only if it is at version expected
+func (o *Node) CompareAndSwap(ctx context.Context, key, expected string, value []byte) error {
+	if expected == "" {
+		return ErrConditionFailed
+	}
+	return o.condWrite(ctx, CondWrite{Key: key, Value: value, Expected: expected}, o.Consistency)
+}
+
+// delete a Key only if it is at version expected
+func (o *Node) DeleteIfVersion(ctx context.Context, key, expected string) error {
+	if expected == "" {
+		return ErrConditionFailed
+	}
+	return o.condWrite(ctx, CondWrite{Key: key, Deleted: true, Expected: expected}, o.Consistency)
+}
+
+// get a Key with its version, to be passed to CompareAndSwap() or DeleteIfVersion()
+func (o *Node) GetWithVersion(ctx context.Context, key string) ([]byte, string, error) {
+	versions, err := o.getVersions(ctx, key, o.Consistency)
+	if err != nil {
+		return nil, "", err
+	}
+	value, found := versions.Resolve()
+	if found == false {
+		return nil, "", ErrNotFound
+	}
+	return value, versions.clock().String(), nil
+}
@@ -0,0 +1,28 @@
+// Clock abstracts the passing of time for a node
+
+package chord
+
+import "time"
+
+// Clock is where a node reads the time, waits and starts background work,
+// so that it can run on a simulated network with virtual time
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	Go(f func())
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) Go(f func()) {
+	go f()
+}
@@ -0,0 +1,148 @@
+// run-time parameters of a node
+
+package chord
+
+import (
+	"config"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+func (c Consistency) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Consistency) UnmarshalText(b []byte) error {
+	res, err := ParseConsistency(string(b))
+	if err != nil {
+		return err
+	}
+	*c = res
+	return nil
+}
+
+func (m LookupMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *LookupMode) UnmarshalText(b []byte) error {
+	res, err := ParseLookupMode(string(b))
+	if err != nil {
+		return err
+	}
+	*m = res
+	return nil
+}
+
+// Config holds the parameters of a node. Every node of a ring must use the same M.
+// A zero field means the default, but for Consistency whose zero is One and Lookup whose zero is Recursive
+type Config struct {
+	M                int             `json:"m"`                  // bits of an ID, at most 160 (SHA-1)
+	SuccessorListLen int             `json:"successor_list_len"` // successors kept to survive failures
+	FailTimes        int             `json:"fail_times"`         // hops of a lookup, and seconds a new node waits for its predecessor
+	Replicas         int             `json:"replicas"`           // copies of each key in a new ring, the owner's included
+	Consistency      Consistency     `json:"consistency"`        // level of Put, Get and Delete
+	Lookup           LookupMode      `json:"lookup"`             // how FindSuccessor walks the ring
+	HopTimeout       config.Duration `json:"hop_timeout"`        // an iterative lookup asks another node past it
+
+	StabilizeInterval        config.Duration `json:"stabilize_interval"`
+	FixFingersInterval       config.Duration `json:"fix_fingers_interval"`
+	CheckPredecessorInterval config.Duration `json:"check_predecessor_interval"`
+	AntiEntropyInterval      config.Duration `json:"anti_entropy_interval"`
+	SweepInterval            config.Duration `json:"sweep_interval"` // expired Keys are removed every interval
+
+	// a tombstone is dropped this long after the delete or expiry it records, by when every replica
+	// should have it: a replica down for longer may bring the deleted value back
+	TombstoneGrace config.Duration `json:"tombstone_grace"`
+}
+
+// function DefaultConfig() returns the parameters a node uses unless told otherwise
+func DefaultConfig() Config {
+	return Config{
+		M:                        160,
+		SuccessorListLen:         160,
+		FailTimes:                32,
+		Replicas:                 DefaultReplicas,
+		Consistency:              Quorum,
+		Lookup:                   Recursive,
+		HopTimeout:               config.Duration(Second),
+		StabilizeInterval:        config.Duration(100 * time.Millisecond),
+		FixFingersInterval:       config.Duration(100 * time.Millisecond),
+		CheckPredecessorInterval: config.Duration(100 * time.Millisecond),
+		AntiEntropyInterval:      config.Duration(5 * Second),
+		SweepInterval:            config.Duration(Second),
+		TombstoneGrace:           config.Duration(24 * time.Hour),
+	}
+}
+
+// method WithDefaults() returns the config with its zero fields set to the defaults
+func (c Config) WithDefaults() Config {
+	def := DefaultConfig()
+	if c.M == 0 {
+		c.M = def.M
+	}
+	if c.SuccessorListLen == 0 {
+		c.SuccessorListLen = def.SuccessorListLen
+	}
+	if c.FailTimes == 0 {
+		c.FailTimes = def.FailTimes
+	}
+	if c.Replicas == 0 {
+		c.Replicas = def.Replicas
+	}
+	if c.HopTimeout == 0 {
+		c.HopTimeout = def.HopTimeout
+	}
+	if c.StabilizeInterval == 0 {
+		c.StabilizeInterval = def.StabilizeInterval
+	}
+	if c.FixFingersInterval == 0 {
+		c.FixFingersInterval = def.FixFingersInterval
+	}
+	if c.CheckPredecessorInterval == 0 {
+		c.CheckPredecessorInterval = def.CheckPredecessorInterval
+	}
+	if c.AntiEntropyInterval == 0 {
+		c.AntiEntropyInterval = def.AntiEntropyInterval
+	}
+	if c.SweepInterval == 0 {
+		c.SweepInterval = def.SweepInterval
+	}
+	if c.TombstoneGrace == 0 {
+		c.TombstoneGrace = def.TombstoneGrace
+	}
+	return c
+}
+
+// method Validate() checks that a node can run with the config
+func (c Config) Validate() error {
+	switch {
+	case c.M < 1 || c.M > 160:
+		return fmt.Errorf("Config: m = %d, must be in [1, 160] ", c.M)
+	case c.SuccessorListLen < 1:
+		return fmt.Errorf("Config: successor_list_len = %d, must be positive ", c.SuccessorListLen)
+	case c.FailTimes < 1:
+		return fmt.Errorf("Config: fail_times = %d, must be positive ", c.FailTimes)
+	case c.Replicas < 1 || c.Replicas > c.SuccessorListLen+1:
+		return fmt.Errorf("Config: replicas = %d, must be in [1, successor_list_len + 1] ", c.Replicas)
+	case c.Consistency < One || c.Consistency > All:
+		return fmt.Errorf("Config: invalid consistency %v ", c.Consistency)
+	case c.Lookup != Recursive && c.Lookup != Iterative:
+		return fmt.Errorf("Config: invalid lookup %v ", c.Lookup)
+	case c.HopTimeout <= 0:
+		return errors.New("Config: hop_timeout must be positive ")
+	case c.StabilizeInterval <= 0 || c.FixFingersInterval <= 0 ||
+		c.CheckPredecessorInterval <= 0 || c.AntiEntropyInterval <= 0 || c.SweepInterval <= 0:
+		return errors.New("Config: intervals must be positive ")
+	case c.TombstoneGrace <= c.AntiEntropyInterval:
+		return errors.New("Config: tombstone_grace must be longer than anti_entropy_interval ")
+	}
+	return nil
+}
+
+// method ringSize() returns 2^M, the number of IDs on the ring
+func (c Config) ringSize() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(c.M))
+}
@@ -0,0 +1,238 @@
+// consistency levels of reads and writes over the replicas of a key
+
+package chord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"trace"
+)
+
+// Consistency is the number of replicas a read or write waits for
+type Consistency int
+
+const (
+	One    Consistency = iota // the first replica which answers
+	Quorum                    // a majority of the replicas
+	All                       // every replica
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case One:
+		return "ONE"
+	case Quorum:
+		return "QUORUM"
+	case All:
+		return "ALL"
+	}
+	return fmt.Sprintf("Consistency(%d)", int(c))
+}
+
+// function ParseConsistency() parses "one", "quorum" or "all"
+func ParseConsistency(str string) (Consistency, error) {
+	switch strings.ToLower(str) {
+	case "one":
+		return One, nil
+	case "quorum":
+		return Quorum, nil
+	case "all":
+		return All, nil
+	}
+	return One, errors.New("Invalid consistency level: " + str + " ")
+}
+
+// method required() returns how many of n replicas must answer, n being the replication
+// factor of the ring and not the replicas found alive, so that ALL fails while a replica is down
+func (c Consistency) required(n int) int {
+	switch c {
+	case One:
+		return 1
+	case Quorum:
+		return n/2 + 1
+	}
+	return n
+}
+
+// result of reading one replica
+type readResult struct {
+	addr     string
+	isOwner  bool
+	versions Siblings
+}
+
+// method lookupReplicas() finds the owner of key and returns it with its replicas
+func (o *Node) lookupReplicas(ctx context.Context, key string) (string, []string, error) {
+	err := o.checkContext(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	owner, err := o.findSuccessor(ctx, o.hash(key))
+	if err != nil {
+		return "", nil, err
+	}
+	var replicas []string
+	err = o.call(ctx, owner.Addr, "RPCNode.GetReplicaSet", 0, &replicas)
+	if err != nil {
+		return "", nil, err
+	}
+	trace.From(ctx).SetOwner(owner.Addr)
+	return owner.Addr, append([]string{owner.Addr}, replicas...), nil
+}
+
+// method writeReplicas() writes to targets (the owner first) until level is met,
+// the remaining replicas are written in the background, outside of ctx.
+// The owner must take the write whatever level is, as a write kept by replicas only would be
+// missed by the reads of the owner: it fails, with ErrOwnerUnreachable if the owner was not reached
+func (o *Node) writeReplicas(ctx context.Context, targets []string, level Consistency, write func(ctx context.Context, addr string, isOwner bool) error) error {
+	need := level.required(o.Replicas)
+	acks := 0
+	var lastErr error
+	for i, addr := range targets {
+		if acks >= need {
+			rest := targets[i:]
+			o.clock.Go(func() {
+				for _, addr := range rest {
+					err := write(context.Background(), addr, false)
+					if err != nil {
+						o.logger("write").Warn("write replica failed", "peer", addr, "err", err)
+					}
+				}
+			})
+			break
+		}
+		if err := o.checkContext(ctx); err != nil {
+			return err
+		}
+		err := write(ctx, addr, i == 0)
+		if err != nil && i == 0 {
+			if unreachable(err) {
+				return fmt.Errorf("%w%s: %w", ErrOwnerUnreachable, addr, err)
+			}
+			return err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		acks++
+	}
+	if acks < need {
+		return tooFewReplicas(level, acks, need, lastErr)
+	}
+	return nil
+}
+
+// function tooFewReplicas() returns the error of a read or write at level which reached
+// got of the need replicas, lastErr being the last failure if any
+func tooFewReplicas(level Consistency, got, need int, lastErr error) error {
+	if lastErr == nil {
+		return fmt.Errorf("%w%v: %d of %d", ErrTooFewReplicas, level, got, need)
+	}
+	return fmt.Errorf("%w%v: %d of %d, last error: %w", ErrTooFewReplicas, level, got, need, lastErr)
+}
+
+// method writeVersion() writes a new version of key to targets until level is met.
+// The owner creates the version by calling method with args, and the version is then
+// merged into the other replicas.
+func (o *Node) writeVersion(ctx context.Context, key string, targets []string, level Consistency, method string, args interface{}) (WriteReply, error) {
+	var reply WriteReply
+	err := o.writeReplicas(ctx, targets, level, func(ctx context.Context, addr string, isOwner bool) error {
+		if isOwner {
+			return o.call(ctx, addr, method, args, &reply)
+		}
+		return o.storeVersions(ctx, addr, false, map[string]Siblings{key: {reply.Version}})
+	})
+	return reply, err
+}
+
+// method retryOwner() runs write, which looks up the replicas of a Key and writes them, again
+// while the owner is unreachable, since a failed owner is replaced once the ring stabilizes
+func (o *Node) retryOwner(ctx context.Context, write func() error) error {
+	var err error
+	for i := 0; i < 5; i++ {
+		if i > 0 {
+			trace.From(ctx).Retry()
+			if e := o.sleepContext(ctx, 200*time.Millisecond); e != nil {
+				return e
+			}
+		}
+		err = write()
+		if errors.Is(err, ErrOwnerUnreachable) == false {
+			return err
+		}
+	}
+	return err
+}
+
+// method storeVersions() merges versions into the Data of the owner or the DataPre of a replica
+func (o *Node) storeVersions(ctx context.Context, addr string, isOwner bool, data map[string]Siblings) error {
+	if isOwner {
+		return o.call(ctx, addr, "RPCNode.MergeData", data, new(int))
+	}
+	return o.call(ctx, addr, "RPCNode.ReplicateData", data, new(int))
+}
+
+// method readReplicas() reads the versions of key from targets (the owner first)
+// until level is met, merges them and repairs the replicas which missed some.
+func (o *Node) readReplicas(ctx context.Context, key string, targets []string, level Consistency) (Siblings, error) {
+	need := level.required(o.Replicas)
+	var results []readResult
+	var lastErr error
+	for i, addr := range targets {
+		if len(results) >= need {
+			break
+		}
+		if err := o.checkContext(ctx); err != nil {
+			return nil, err
+		}
+		method := "RPCNode.GetValueDataPre"
+		if i == 0 {
+			method = "RPCNode.GetValue"
+		}
+		var versions Siblings
+		err := o.call(ctx, addr, method, key, &versions)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		results = append(results, readResult{addr, i == 0, versions})
+	}
+	if len(results) < need {
+		return nil, tooFewReplicas(level, len(results), need, lastErr)
+	}
+
+	var merged Siblings
+	for _, r := range results {
+		merged = mergeVersions(merged, r.versions)
+	}
+	var stale []readResult
+	for _, r := range results {
+		if !sameVersions(r.versions, merged) {
+			stale = append(stale, r)
+		}
+	}
+	if len(stale) > 0 {
+		o.clock.Go(func() { o.readRepair(key, merged, stale) })
+	}
+	return merged, nil
+}
+
+// method readRepair() merges the versions read into the replicas which missed some
+func (o *Node) readRepair(key string, versions Siblings, stale []readResult) {
+	for _, r := range stale {
+		err := o.storeVersions(context.Background(), r.addr, r.isOwner, map[string]Siblings{key: versions})
+		if err != nil {
+			o.logger("read_repair").Warn("read repair failed", "peer", r.addr, "err", err)
+		}
+	}
+}
+
+// method GetReplicaSet() returns the replicas of the current node's data
+func (o *Node) GetReplicaSet(args int, res *[]string) error {
+	*res = o.replicaSet()
+	return nil
+}
@@ -0,0 +1,88 @@
+// errors of the client API, and deadlines of contexts on the clock of a node
+
+package chord
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"time"
+)
+
+var (
+	ErrNotFound           = errors.New("Key not found ")
+	ErrNoSuccessor        = errors.New("No valid successor ")
+	ErrTimeout            = errors.New("Deadline exceeded ")
+	ErrLookupHopsExceeded = errors.New("Lookup failure: too many hops ")
+	ErrConditionFailed    = errors.New("Condition of the write does not hold ")
+	ErrInvalidTTL         = errors.New("TTL must be positive ")
+	ErrStorage            = errors.New("Storage failed to write ")
+	ErrOwnerUnreachable   = errors.New("Owner of the Key unreachable ")
+	ErrTooFewReplicas     = errors.New("Too few replicas answered ")
+	ErrStopped            = errors.New("Node stopped ")
+)
+
+// function remoteError() returns the error of this package which err carries over RPC,
+// which only keeps the text of an error
+func remoteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, e := range []error{ErrNotFound, ErrNoSuccessor, ErrTimeout, ErrLookupHopsExceeded, ErrConditionFailed} {
+		if err.Error() == e.Error() {
+			return e
+		}
+	}
+	return err
+}
+
+// function unreachable() returns whether err is a failure to reach a node, rather than an error
+// the node returned, which net/rpc and simnet carry as rpc.ServerError, or the end of the context.
+// A node which answers that it stopped is as good as unreachable
+func unreachable(err error) bool {
+	var remote rpc.ServerError
+	if errors.As(err, &remote) {
+		return remote.Error() == ErrStopped.Error()
+	}
+	return err != nil && errors.Is(err, ErrTimeout) == false && errors.Is(err, context.Canceled) == false
+}
+
+// function contextError() returns ErrTimeout for the deadline of a context, as checkContext() does
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return err
+}
+
+// function deadline() returns the deadline of ctx, zero if it has none
+func deadline(ctx context.Context) time.Time {
+	d, _ := ctx.Deadline()
+	return d
+}
+
+// method checkContext() returns ErrTimeout once the deadline of ctx has passed on the clock
+// of the node, which is virtual on a simulated network, and ctx.Err() once ctx is canceled
+func (o *Node) checkContext(ctx context.Context) error {
+	if d, ok := ctx.Deadline(); ok && o.clock.Now().Before(d) == false {
+		return ErrTimeout
+	}
+	if ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// method sleepContext() sleeps for d unless ctx ends first
+func (o *Node) sleepContext(ctx context.Context, d time.Duration) error {
+	err := o.checkContext(ctx)
+	if err != nil {
+		return err
+	}
+	if dl, ok := ctx.Deadline(); ok && o.clock.Now().Add(d).After(dl) {
+		o.clock.Sleep(dl.Sub(o.clock.Now()))
+		return ErrTimeout
+	}
+	o.clock.Sleep(d)
+	return o.checkContext(ctx)
+}
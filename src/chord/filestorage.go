@@ -0,0 +1,313 @@
+// on-disk storage: a snapshot plus a write-ahead log
+
+package chord
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// DefaultSnapshotEvery is the number of log records after which a FileStorage takes a snapshot
+const DefaultSnapshotEvery = 1024
+
+// FileStorage is a Storage kept in memory and on disk at path+".snap" and path+".wal".
+// Every change is appended to the log before it is applied, and the log is folded into
+// a new snapshot every SnapshotEvery records. Opening the same path again replays both,
+// dropping a record torn by a crash at the end of the log.
+//
+// With Sync, the default, every record is fsynced before the write is acknowledged, so that
+// an acknowledged write survives a crash of the machine. Without it, records are left to the
+// page cache of the OS: they survive a crash of the process, but a crash of the machine may
+// lose the writes since the last snapshot.
+type FileStorage struct {
+	SnapshotEvery int
+	Sync          bool // fsync every record, otherwise only snapshots are synced
+
+	path    string
+	data    memStorage
+	wal     *os.File
+	records int
+	size    int64 // of the log up to the last record written whole
+	failed  error // set if a torn record could not be cut off the log, which takes no more records
+}
+
+// one record of the log
+type walRecord struct {
+	Key      string
+	Versions Siblings
+	Delete   bool
+}
+
+// a version as written before values were bytes, still read from older files
+type legacyVersioned struct {
+	Value   string
+	Deleted bool
+	Clock   VClock
+	Time    int64
+	Expire  int64
+}
+
+type legacyWalRecord struct {
+	Key      string
+	Versions []legacyVersioned
+	Delete   bool
+}
+
+func fromLegacy(versions []legacyVersioned) Siblings {
+	res := make(Siblings, len(versions))
+	for i, v := range versions {
+		res[i] = Versioned{[]byte(v.Value), v.Deleted, v.Clock, v.Time, v.Expire}
+	}
+	return res
+}
+
+// function OpenFileStorage() opens or creates the FileStorage at path
+func OpenFileStorage(path string) (*FileStorage, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStorage{SnapshotEvery: DefaultSnapshotEvery, Sync: true, path: path, data: make(memStorage)}
+	err = s.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	err = s.replay()
+	if err != nil {
+		return nil, err
+	}
+	s.wal, err = os.OpenFile(path+".wal", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// method loadSnapshot() reads the last snapshot, if any
+func (s *FileStorage) loadSnapshot() error {
+	f, err := os.Open(s.path + ".snap")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	data := make(map[string]Siblings)
+	err = gob.NewDecoder(bytes.NewReader(raw)).Decode(&data)
+	if err != nil {
+		legacy := make(map[string][]legacyVersioned)
+		if gob.NewDecoder(bytes.NewReader(raw)).Decode(&legacy) != nil {
+			return fmt.Errorf("Snapshot %s.snap corrupted: %v ", s.path, err)
+		}
+		for key, versions := range legacy {
+			data[key] = fromLegacy(versions)
+		}
+	}
+	s.data = data
+	return nil
+}
+
+// method replay() applies the log to the snapshot and cuts off a torn last record
+func (s *FileStorage) replay() error {
+	f, err := os.OpenFile(s.path+".wal", os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var offset int64
+	header := make([]byte, 8)
+	for {
+		_, err = io.ReadFull(f, header)
+		if err != nil {
+			break
+		}
+		payload := make([]byte, binary.LittleEndian.Uint32(header[:4]))
+		_, err = io.ReadFull(f, payload)
+		if err != nil || crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+			err = errors.New("torn record ")
+			break
+		}
+		var rec walRecord
+		err = gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec)
+		if err != nil {
+			var legacy legacyWalRecord
+			if gob.NewDecoder(bytes.NewReader(payload)).Decode(&legacy) != nil {
+				break
+			}
+			rec, err = walRecord{legacy.Key, fromLegacy(legacy.Versions), legacy.Delete}, nil
+		}
+		s.apply(rec)
+		s.records++
+		offset += int64(len(header) + len(payload))
+	}
+	s.size = offset
+	if err != io.EOF {
+		slog.Default().Warn("log torn, cut", LogOp, "replay", "path", s.path+".wal", "offset", offset, "err", err)
+		return f.Truncate(offset)
+	}
+	return nil
+}
+
+func (s *FileStorage) apply(rec walRecord) {
+	if rec.Delete {
+		delete(s.data, rec.Key)
+	} else {
+		s.data[rec.Key] = rec.Versions
+	}
+}
+
+// method append() writes a record to the log and applies it. A record not written or synced
+// whole is cut off the log, so that the records written after it are not lost at the next replay.
+// A snapshot which fails leaves the record in the log, where it is kept
+func (s *FileStorage) append(rec walRecord) error {
+	if s.wal == nil {
+		return errors.New("Storage " + s.path + " closed ")
+	}
+	if s.failed != nil {
+		return s.failed
+	}
+	var payload bytes.Buffer
+	err := gob.NewEncoder(&payload).Encode(rec)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 8, 8+payload.Len())
+	binary.LittleEndian.PutUint32(buf[:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload.Bytes()))
+	n, err := s.wal.Write(append(buf, payload.Bytes()...))
+	if err == nil && s.Sync {
+		err = s.wal.Sync()
+	}
+	if err != nil {
+		s.cut(err)
+		return err
+	}
+	s.size += int64(n)
+	s.apply(rec)
+	s.records++
+	if s.SnapshotEvery > 0 && s.records >= s.SnapshotEvery {
+		err = s.Snapshot()
+		if err != nil { // taken again after the next record
+			slog.Default().Warn("snapshot failed", LogOp, "snapshot", "path", s.path+".snap", "err", err)
+		}
+	}
+	return nil
+}
+
+// method cut() cuts the log back to its last whole record after writing one failed with err,
+// or marks the storage failed if it cannot
+func (s *FileStorage) cut(err error) {
+	e := s.wal.Truncate(s.size)
+	if e == nil && s.Sync {
+		e = s.wal.Sync()
+	}
+	if e != nil {
+		s.failed = fmt.Errorf("Storage %s failed: %v, then %v ", s.path, err, e)
+		slog.Default().Error("log not cut", LogOp, "append", "path", s.path+".wal", "err", s.failed)
+	}
+}
+
+// function syncDir() fsyncs a directory, so that a file renamed in it stays renamed after a crash
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// method Snapshot() writes all Keys to a new snapshot and empties the log
+func (s *FileStorage) Snapshot() error {
+	tmp := s.path + ".snap.tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(map[string]Siblings(s.data))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, s.path+".snap")
+	if err != nil {
+		return err
+	}
+	err = syncDir(filepath.Dir(s.path))
+	if err != nil {
+		return err
+	}
+	// a crash before the truncation only replays records already in the snapshot
+	err = s.wal.Truncate(0)
+	if err != nil {
+		return err
+	}
+	s.records, s.size = 0, 0
+	return nil
+}
+
+func (s *FileStorage) Get(key string) Siblings {
+	return s.data.Get(key)
+}
+
+func (s *FileStorage) Set(key string, versions Siblings) error {
+	return s.append(walRecord{Key: key, Versions: versions})
+}
+
+func (s *FileStorage) Delete(key string) error {
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+	return s.append(walRecord{Key: key, Delete: true})
+}
+
+func (s *FileStorage) Range(f func(key string, versions Siblings)) {
+	s.data.Range(f)
+}
+
+func (s *FileStorage) Len() int {
+	return len(s.data)
+}
+
+// method Close() takes a last snapshot and closes the log
+func (s *FileStorage) Close() error {
+	if s.wal == nil {
+		return nil
+	}
+	err := s.Snapshot()
+	if err != nil {
+		_ = s.wal.Close()
+		s.wal = nil
+		return err
+	}
+	err = s.wal.Close()
+	s.wal = nil
+	return err
+}
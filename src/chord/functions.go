@@ -5,15 +5,9 @@ package chord
 import (
 	"crypto/sha1"
 	"math/big"
-	"net"
-	"net/rpc"
-	"time"
 )
 
-var (
-	two     = big.NewInt(2)
-	hashMod = new(big.Int).Exp(two, big.NewInt(M), nil)
-)
+var two = big.NewInt(2)
 
 // hash functions
 func hashString(elt string) *big.Int {
@@ -22,11 +16,11 @@ func hashString(elt string) *big.Int {
 	return new(big.Int).SetBytes(hash.Sum(nil))
 }
 
-// used to calculate the destination of finger entries
-func jump(n *big.Int, power int) *big.Int {
+// used to calculate the destination of finger entries on a ring of ring IDs
+func jump(n *big.Int, power int, ring *big.Int) *big.Int {
 	gap := new(big.Int).Exp(two, big.NewInt(int64(power)-1), nil)
 	res := new(big.Int).Add(n, gap)
-	return new(big.Int).Mod(res, hashMod)
+	return new(big.Int).Mod(res, ring)
 }
 
 // check whether elt is between start and end
@@ -39,80 +33,3 @@ func between(start, elt, end *big.Int, inclusive bool) bool {
 		return start.Cmp(elt) < 0 || elt.Cmp(end) < 0 || (inclusive && elt.Cmp(end) == 0)
 	}
 }
-
-// function to get local address(ip address)
-func GetLocalAddress() string {
-	var localaddress string
-
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		panic("init: failed to find network interfaces")
-	}
-
-	// find the first non-loopback interface with an IP address
-	for _, elt := range ifaces {
-		if elt.Flags&net.FlagLoopback == 0 && elt.Flags&net.FlagUp != 0 {
-			addrs, err := elt.Addrs()
-			if err != nil {
-				panic("init: failed to get addresses for network interface")
-			}
-
-			for _, addr := range addrs {
-				ipnet, ok := addr.(*net.IPNet)
-				if ok {
-					if ip4 := ipnet.IP.To4(); len(ip4) == net.IPv4len {
-						localaddress = ip4.String()
-						break
-					}
-				}
-			}
-		}
-	}
-	if localaddress == "" {
-		panic("init: failed to find non-loopback interface with valid address on this node")
-	}
-
-	return localaddress
-}
-
-// function Dial() to dial a given address
-func Dial(addr string) (*rpc.Client, error) {
-	var err error
-	var client *rpc.Client
-	for i := 0; i < 3; i++ {
-		client, err = rpc.Dial("tcp", addr)
-		if err == nil {
-			return client, err
-		} else {
-			time.Sleep(Second / 2)
-		}
-	}
-	return nil, err
-}
-
-// function Ping()
-func Ping(addr string) bool {
-	for i := 0; i < 3; i++ {
-		chOK := make(chan bool)
-		go func() {
-			client, err := rpc.Dial("tcp", addr)
-			if err == nil {
-				err = client.Close()
-				chOK <- true
-			} else {
-				chOK <- false
-			}
-		}()
-		select {
-		case ok := <-chOK:
-			if ok {
-				return true
-			} else {
-				continue
-			}
-		case <-time.After(Second / 2):
-			break
-		}
-	}
-	return false
-}
@@ -0,0 +1,224 @@
+// graceful leave: the keys of a leaving node are handed over with acknowledgements
+
+package chord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrLeaveFailed  = errors.New("Leave failed: no successor took over the keys ")
+	ErrNotSuccessor = errors.New("Not the successor of the leaving node ")
+)
+
+// HandOffArgs are the keys of a leaving node, sent to its successor
+type HandOffArgs struct {
+	From        Edge  // the leaving node
+	Predecessor *Edge // of the leaving node, the new predecessor of its successor (nil if unknown)
+	Data        map[string]Siblings
+	DataPre     map[string]Siblings
+}
+
+// HandOffReply acknowledges a handoff once the successor owns the keys
+type HandOffReply struct {
+	Keys     int // keys of the leaving node merged into the Data of the successor
+	Replicas int // replicas of the successor which acknowledged their copies
+}
+
+// method HandOff() takes over the keys and the range of a leaving predecessor, all or nothing.
+// It copies the keys to each replica but the leaving node, which must all acknowledge, then
+// moves them into Data, and only then takes the predecessor of the leaving node as its own.
+// If it fails the node keeps no keys of the leaving node in Data, which still owns them
+func (o *Node) HandOff(args HandOffArgs, res *HandOffReply) error {
+	if o.ON == false {
+		return ErrStopped
+	}
+	pred := o.Predecessor
+	if pred != nil && pred.Addr != args.From.Addr && pred.Addr != o.Addr &&
+		between(pred.ID, args.From.ID, o.ID, false) == false && o.Ping(pred.Addr) {
+		return ErrNotSuccessor // a node joined in between
+	}
+	err := o.FixSuccessors()
+	if err != nil {
+		return err
+	}
+
+	data := args.Data
+	o.DataPre.lock.Lock()
+	for k, v := range data {
+		data[k] = mergeVersions(v, o.DataPre.store.Get(k))
+	}
+	o.DataPre.lock.Unlock()
+	// copies at the replicas of keys not taken over are dropped as stray, see dropStrayCopies()
+	for _, addr := range o.replicaSet() {
+		if addr == args.From.Addr {
+			continue // still answering, but not for long
+		}
+		err := o.transport.Call(addr, "RPCNode.ReplicateData", data, new(int))
+		if err != nil {
+			return fmt.Errorf("Replica %s did not take the copies: %w", addr, err)
+		}
+		res.Replicas++
+	}
+
+	now := o.clock.Now().UnixNano()
+	err = o.DataPre.merge(args.DataPre, now)
+	if err != nil {
+		return err
+	}
+	err = o.takeKeys(data, now)
+	if err != nil {
+		return err
+	}
+	if args.Predecessor != nil {
+		o.Predecessor = args.Predecessor
+		if o.Predecessor.Addr == o.Addr { // the node is alone once the leaving one is gone
+			o.takeOver(nil)
+		}
+	}
+	res.Keys = len(data)
+	return nil
+}
+
+// method takeKeys() merges the keys handed off by a leaving node into Data and drops their
+// copies from DataPre, owned now. If the storage fails, both are put back as they were
+func (o *Node) takeKeys(data map[string]Siblings, now int64) error {
+	o.DataPre.lock.Lock()
+	defer o.DataPre.lock.Unlock()
+	o.Data.lock.Lock()
+	defer o.Data.lock.Unlock()
+	oldData := make(map[string]Siblings, len(data))
+	oldPre := make(map[string]Siblings, len(data))
+	var err error
+	for k, v := range data {
+		oldData[k], oldPre[k] = o.Data.store.Get(k), o.DataPre.store.Get(k)
+		merged := mergeVersions(oldData[k], v).expire(now)
+		if len(merged) > 0 && sameVersions(oldData[k], merged) == false {
+			err = o.Data.set(k, merged)
+		}
+		if err == nil && len(oldPre[k]) > 0 {
+			err = o.DataPre.remove(k)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	for k := range oldData {
+		if e := restore(&o.Data, k, oldData[k]); e != nil {
+			o.logger("handoff").Error("roll back failed", "err", e)
+		}
+		if e := restore(&o.DataPre, k, oldPre[k]); e != nil {
+			o.logger("handoff").Error("roll back failed", "err", e)
+		}
+	}
+	return err
+}
+
+// function restore() puts back the versions a key had in m, the lock being held
+func restore(m *KVMap, key string, versions Siblings) error {
+	if sameVersions(m.store.Get(key), versions) {
+		return nil
+	}
+	if len(versions) == 0 {
+		return m.remove(key)
+	}
+	return m.set(key, versions)
+}
+
+// method Leave() makes the node leave the ring. Its keys are handed over to the first successor
+// which acknowledges them, see HandOff(), trying the next successors of the list when one fails,
+// after which the predecessor is pointed to that successor and the keys written meanwhile are
+// handed over too. If no successor takes over the keys, it returns ErrLeaveFailed and the node
+// stays in the ring, to leave again or be stopped
+func (o *Node) Leave(ctx context.Context) (err error) {
+	defer func(start time.Time) { o.observe("leave", start, err) }(o.clock.Now())
+	var succ Edge
+	var reply HandOffReply
+	var sent map[string]Siblings
+	lastErr := ErrNoSuccessor
+	for round := 0; round < 5 && succ.Addr == ""; round++ {
+		if round > 0 {
+			err := o.sleepContext(ctx, time.Duration(o.cfg.StabilizeInterval))
+			if err != nil {
+				return fmt.Errorf("%wlast error: %w", ErrLeaveFailed, err)
+			}
+			o.Stabilize(false) // a node may have joined in between
+		}
+		err := o.FixSuccessors()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if o.Successor[1].Addr == o.Addr {
+			o.ON = false
+			o.logger("quit").Info("quit success, the ring is empty")
+			return nil
+		}
+		sent = o.Data.copy()
+		for _, e := range o.successors() {
+			reply = HandOffReply{}
+			err := o.call(ctx, e.Addr, "RPCNode.HandOff", o.handOffArgs(sent, o.DataPre.copy()), &reply)
+			if err == nil {
+				succ = e
+				break
+			}
+			o.logger("quit").Warn("hand off failed", "peer", e.Addr, "err", err)
+			lastErr = err
+		}
+	}
+	if succ.Addr == "" {
+		return fmt.Errorf("%wlast error: %w", ErrLeaveFailed, lastErr)
+	}
+	o.logger("quit").Info("keys handed off", "peer", succ.Addr, "keys", reply.Keys, "replicas", reply.Replicas)
+
+	if o.Predecessor != nil {
+		err := o.call(ctx, o.Predecessor.Addr, "RPCNode.SetSuccessor", succ, new(int))
+		if err != nil { // it finds the successor once it sees the node is gone
+			o.logger("quit").Warn("set successor failed", "peer", o.Predecessor.Addr, "err", err)
+		}
+	}
+
+	// writes which reached the node before the predecessor pointed past it
+	late := make(map[string]Siblings)
+	for k, v := range o.Data.copy() {
+		if sameVersions(v, sent[k]) == false {
+			late[k] = v
+		}
+	}
+	if len(late) > 0 {
+		err := o.call(ctx, succ.Addr, "RPCNode.HandOff", o.handOffArgs(late, nil), &reply)
+		if err != nil {
+			return fmt.Errorf("%d keys written while leaving not handed off: %w", len(late), err)
+		}
+	}
+
+	o.ON = false
+	o.logger("quit").Info("quit success")
+	return nil
+}
+
+// method handOffArgs() returns the handoff of data and dataPre by the node
+func (o *Node) handOffArgs(data, dataPre map[string]Siblings) HandOffArgs {
+	return HandOffArgs{From: Edge{o.Addr, o.ID}, Predecessor: o.Predecessor, Data: data, DataPre: dataPre}
+}
+
+// method successors() returns the distinct entries of the successor list, the node itself aside
+func (o *Node) successors() []Edge {
+	o.sLock.Lock()
+	defer o.sLock.Unlock()
+	var res []Edge
+	seen := map[string]bool{o.Addr: true, "": true}
+	for _, e := range o.Successor[1:] {
+		if seen[e.Addr] == false {
+			seen[e.Addr] = true
+			res = append(res, e)
+		}
+	}
+	return res
+}
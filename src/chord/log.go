@@ -0,0 +1,25 @@
+// logging of a node: every entry carries the address of the node and the operation logging it
+
+package chord
+
+import (
+	"log/slog"
+)
+
+// keys of the attributes every entry of a node carries
+const (
+	LogNode = "node"
+	LogOp   = "op"
+)
+
+// method SetLogger() replaces the logger of the node, slog.Default() when the node was made
+func (o *Node) SetLogger(l *slog.Logger) {
+	o.log = l.With(LogNode, o.Addr)
+	o.Data.log = o.logger("storage")
+	o.DataPre.log = o.logger("storage")
+}
+
+// method logger() returns the logger of the node for the operation op
+func (o *Node) logger(op string) *slog.Logger {
+	return o.log.With(LogOp, op)
+}
@@ -0,0 +1,217 @@
+// lookup modes: recursive, where each hop forwards the lookup, and iterative,
+// where the originator asks each hop for the next and drives the lookup itself
+
+package chord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+	"trace"
+)
+
+// LookupMode is how a node finds the successor of an ID
+type LookupMode int
+
+const (
+	Recursive LookupMode = iota // each hop calls the next and holds its call open
+	Iterative                   // the originator calls every hop
+)
+
+// the nodes a hop of an iterative lookup offers, the next one being the fallback of the first
+const lookupAlternatives = 3
+
+func (m LookupMode) String() string {
+	switch m {
+	case Recursive:
+		return "recursive"
+	case Iterative:
+		return "iterative"
+	}
+	return fmt.Sprintf("LookupMode(%d)", int(m))
+}
+
+// function ParseLookupMode() parses "recursive" or "iterative"
+func ParseLookupMode(str string) (LookupMode, error) {
+	switch strings.ToLower(str) {
+	case "recursive":
+		return Recursive, nil
+	case "iterative":
+		return Iterative, nil
+	}
+	return Recursive, errors.New("Invalid lookup mode: " + str + " ")
+}
+
+// HopReply is the answer of a node to a hop of an iterative lookup
+type HopReply struct {
+	Done      bool   // Successor is the successor of the ID
+	Successor Edge   //
+	Next      []Edge // nodes preceding the ID to ask next, the closest first
+}
+
+// method NextHop() answers a hop of an iterative lookup of id: the successor of id if the
+// node knows it, otherwise the closest preceding nodes of its finger table and successor list
+func (o *Node) NextHop(id *big.Int, res *HopReply) error {
+	err := o.FixSuccessors()
+	if err != nil {
+		return err
+	}
+	succ := o.Successor[1]
+	if succ.Addr == o.Addr || id.Cmp(o.ID) == 0 {
+		*res = HopReply{Done: true, Successor: Edge{o.Addr, new(big.Int).Set(o.ID)}}
+		return nil
+	}
+	if between(o.ID, id, succ.ID, true) {
+		*res = HopReply{Done: true, Successor: Edge{succ.Addr, new(big.Int).Set(succ.ID)}}
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	add := func(e Edge) {
+		if e.ID != nil && seen[e.Addr] == false && between(o.ID, e.ID, id, false) {
+			seen[e.Addr] = true
+			res.Next = append(res.Next, Edge{e.Addr, new(big.Int).Set(e.ID)})
+		}
+	}
+	for i := o.cfg.M; i > 0 && len(res.Next) < lookupAlternatives; i-- {
+		add(o.Finger[i])
+	}
+	o.sLock.Lock()
+	for i := len(o.Successor) - 1; i > 0; i-- {
+		if o.Successor[i].Addr != o.Addr {
+			add(o.Successor[i])
+		}
+	}
+	o.sLock.Unlock()
+	if len(res.Next) == 0 {
+		res.Next = []Edge{{succ.Addr, new(big.Int).Set(succ.ID)}}
+	}
+	return nil
+}
+
+// method iterativeFindSuccessor() finds the successor of id, asking start first. A hop
+// which fails or does not answer within HopTimeout of the config, or before ctx ends, is replaced by the next
+// alternative offered by the hop before. path is the nodes which answered, in order.
+// Every hop is recorded in the trace of ctx, a failed one as a retry
+func (o *Node) iterativeFindSuccessor(ctx context.Context, id *big.Int, start Edge) (Edge, []string, error) {
+	t := trace.From(ctx)
+	var path []string
+	visited := make(map[string]bool)
+	if start.Addr != o.Addr {
+		visited[o.Addr] = true // a joining node is not in the ring yet
+	}
+	next := []Edge{start}
+	var lastErr error
+	for hops := 0; hops < o.cfg.FailTimes; hops++ {
+		if err := o.checkContext(ctx); err != nil {
+			return Edge{}, path, err
+		}
+		for len(next) > 0 && visited[next[0].Addr] {
+			next = next[1:]
+		}
+		if len(next) == 0 {
+			if lastErr == nil {
+				lastErr = ErrNoSuccessor
+			}
+			return Edge{}, path, fmt.Errorf("Lookup of %v failed after %v: %w", id, path, lastErr)
+		}
+		hop := next[0]
+		next = next[1:]
+		visited[hop.Addr] = true
+
+		var reply HopReply
+		var err error
+		start := o.clock.Now()
+		if hop.Addr == o.Addr {
+			err = o.NextHop(id, &reply)
+		} else {
+			timeout := time.Duration(o.cfg.HopTimeout)
+			if d, ok := ctx.Deadline(); ok {
+				timeout = min(timeout, d.Sub(o.clock.Now()))
+			}
+			err = callTimeout(o.transport, hop.Addr, "RPCNode.NextHop", id, &reply, timeout)
+		}
+		t.Add(hop.Addr, "RPCNode.NextHop", o.clock.Now().Sub(start), err)
+		if err != nil {
+			o.logger("lookup").Debug("hop failed, trying an alternative", "peer", hop.Addr, "err", err)
+			t.Retry()
+			lastErr = remoteError(err)
+			continue
+		}
+		path = append(path, hop.Addr)
+		if reply.Done {
+			o.metrics.hops.Observe(float64(len(path) - 1))
+			return reply.Successor, path, nil
+		}
+		// the nodes offered are closer to id than the alternatives left from before
+		next = append(reply.Next, next...)
+	}
+	return Edge{}, path, fmt.Errorf("Lookup of %v failed after %v: %w", id, path, ErrLookupHopsExceeded)
+}
+
+// TracedEdge answers a traced recursive lookup: the successor found, and the hops the lookup
+// was forwarded to past the node asked, in order
+type TracedEdge struct {
+	Successor Edge
+	Hops      []trace.Hop
+}
+
+// method TraceSuccessor() is FindSuccessor() recording the hops the lookup is forwarded to.
+// The latency of a hop is the time it took but for the hops past it, so that they add up
+// to the time of the lookup
+func (o *Node) TraceSuccessor(pos *LookupType, res *TracedEdge) error {
+	succ, next, err := o.successorStep(pos)
+	if err != nil || next.Addr == "" {
+		res.Successor = succ
+		return err
+	}
+	start := o.clock.Now()
+	var reply TracedEdge
+	err = o.forward(next.Addr, "RPCNode.TraceSuccessor", pos, &reply)
+	if err != nil {
+		return remoteError(err)
+	}
+	hop := trace.Hop{Addr: next.Addr, Method: "RPCNode.TraceSuccessor", Latency: o.clock.Now().Sub(start)}
+	for _, h := range reply.Hops {
+		hop.Latency -= h.Latency
+	}
+	res.Successor = reply.Successor
+	res.Hops = append([]trace.Hop{hop}, reply.Hops...)
+	return nil
+}
+
+// method findSuccessor() finds the successor of id in the lookup mode of the config.
+// The hops of a traced lookup are recorded in its trace, which a recursive lookup gets
+// back from the hops, see TraceSuccessor()
+func (o *Node) findSuccessor(ctx context.Context, id *big.Int) (Edge, error) {
+	if o.cfg.Lookup == Iterative {
+		res, _, err := o.iterativeFindSuccessor(ctx, id, Edge{o.Addr, o.ID})
+		return res, err
+	}
+	pos := &LookupType{ID: id, Deadline: deadline(ctx)}
+	if t := trace.From(ctx); t != nil {
+		var res TracedEdge
+		start := o.clock.Now()
+		err := o.TraceSuccessor(pos, &res)
+		if err != nil { // the hops past the node are lost with the reply
+			t.Add(o.Addr, "RPCNode.TraceSuccessor", o.clock.Now().Sub(start), err)
+			return Edge{}, err
+		}
+		for _, h := range res.Hops {
+			t.Add(h.Addr, h.Method, h.Latency, nil)
+		}
+		return res.Successor, nil
+	}
+	var res Edge
+	err := o.FindSuccessor(pos, &res)
+	return res, err
+}
+
+// method Lookup() finds the node owning key with an iterative lookup, whatever the mode of
+// the config, and returns it with the nodes the lookup went through
+func (o *Node) Lookup(ctx context.Context, key string) (Edge, []string, error) {
+	return o.iterativeFindSuccessor(ctx, o.hash(key), Edge{o.Addr, o.ID})
+}
@@ -0,0 +1,106 @@
+// metrics of a node: calls, lookups, stabilization, keys and latency of operations
+
+package chord
+
+import (
+	"context"
+	"errors"
+	"metrics"
+	"time"
+)
+
+var errPing = errors.New("Ping failure ")
+
+// nodeMetrics are the metrics of a node, labeled by its address
+type nodeMetrics struct {
+	reg  *metrics.Registry
+	node string
+
+	hops      *metrics.Histogram
+	dropped   *metrics.Counter
+	stabilize *metrics.Counter
+	expired   *metrics.Counter
+	collected *metrics.Counter
+}
+
+// method SetMetrics() registers the metrics of the node in reg, metrics.Default when the node was made
+func (o *Node) SetMetrics(reg *metrics.Registry) {
+	m := &nodeMetrics{reg: reg, node: o.Addr}
+	m.hops = reg.Histogram("chord_lookup_hops", "Hops of the recursive lookups which ended at the node, and of the iterative lookups of the node.",
+		metrics.HopBuckets, LogNode, o.Addr)
+	m.dropped = reg.Counter("chord_successors_dropped_total", "Dead successors dropped from the successor list.",
+		LogNode, o.Addr)
+	m.stabilize = reg.Counter("chord_stabilize_rounds_total", "Rounds of stabilization.", LogNode, o.Addr)
+	m.expired = reg.Counter("chord_keys_expired_total", "Expired keys replaced by tombstones by the sweeper.", LogNode, o.Addr)
+	m.collected = reg.Counter("chord_tombstones_collected_total", "Tombstones past the grace period removed by the sweeper.", LogNode, o.Addr)
+	reg.GaugeFunc("chord_keys", "Keys kept by the node, tombstones included.",
+		func() float64 { return float64(o.Data.len()) }, LogNode, o.Addr, "map", "data")
+	reg.GaugeFunc("chord_keys", "Keys kept by the node, tombstones included.",
+		func() float64 { return float64(o.DataPre.len()) }, LogNode, o.Addr, "map", "datapre")
+	o.metrics = m
+}
+
+// method rpc() counts a call of method by the node
+func (m *nodeMetrics) rpc(method string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.reg.Counter("chord_rpc_calls_total", "Calls made by the node, by method and result.",
+		LogNode, m.node, "method", method, "result", result).Inc()
+}
+
+// method op() records the latency and the result of an operation started at start,
+// a missing key or a failed condition being no error
+func (m *nodeMetrics) op(op string, start, end time.Time, err error) {
+	m.reg.Histogram("chord_op_duration_seconds", "Latency of the operations of the node.",
+		metrics.LatencyBuckets, LogNode, m.node, LogOp, op).Observe(end.Sub(start).Seconds())
+	if err != nil && err != ErrNotFound && err != ErrConditionFailed {
+		m.reg.Counter("chord_op_errors_total", "Failed operations of the node.",
+			LogNode, m.node, LogOp, op).Inc()
+	}
+}
+
+// method remove() drops the metrics of the node
+func (m *nodeMetrics) remove() {
+	m.reg.Remove(LogNode, m.node)
+}
+
+// meteredTransport counts the calls of a node
+type meteredTransport struct {
+	Transport
+	o *Node
+}
+
+func (t meteredTransport) Call(addr, method string, args, reply interface{}) error {
+	err := t.Transport.Call(addr, method, args, reply)
+	t.o.metrics.rpc(method, err)
+	return err
+}
+
+func (t meteredTransport) CallTimeout(addr, method string, args, reply interface{}, timeout time.Duration) error {
+	err := callTimeout(t.Transport, addr, method, args, reply, timeout)
+	t.o.metrics.rpc(method, err)
+	return err
+}
+
+func (t meteredTransport) CallContext(ctx context.Context, addr, method string, args, reply interface{}) error {
+	err := callContext(ctx, t.Transport, addr, method, args, reply)
+	t.o.metrics.rpc(method, err)
+	return err
+}
+
+func (t meteredTransport) Ping(addr string) bool {
+	ok := t.Transport.Ping(addr)
+	if ok {
+		t.o.metrics.rpc("Ping", nil)
+	} else {
+		t.o.metrics.rpc("Ping", errPing)
+	}
+	return ok
+}
+
+// method observe() records an operation of the node started at start
+func (o *Node) observe(op string, start time.Time, err error) {
+	o.metrics.op(op, start, o.clock.Now(), err)
+}
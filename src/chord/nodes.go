@@ -3,20 +3,17 @@
 package chord
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/big"
+	"metrics"
 	"sync"
 	"time"
+	"trace"
 )
 
-const (
-	M                = 160
-	successorListLen = M
-	Second           = 1000 * time.Millisecond
-	FailTimes        = 32
-)
+const Second = 1000 * time.Millisecond
 
 // define Edge, KVMap & Node type
 type Edge struct {
@@ -25,96 +22,198 @@ type Edge struct {
 }
 
 type KVMap struct {
-	Map  map[string]string
-	lock sync.Mutex
+	store  Storage
+	lock   sync.Mutex
+	log    *slog.Logger
+	writes uint64 // counts the writes, so that what is built from the map can be kept until the next one
 }
 
 type KVPair struct {
-	Key, Value string
+	Key    string
+	Value  []byte
+	Expire int64 // wall time in UnixNano the pair expires at, 0 if it never does
 }
 
 type Node struct {
 	Addr string
 	ID   *big.Int
 
-	Successor [successorListLen + 1]Edge
+	Successor []Edge // Successor[1..SuccessorListLen]
 	sLock     sync.Mutex
 
 	Predecessor *Edge
-	Finger      [M + 1]Edge
+	Finger      []Edge // Finger[1..M]
 
 	Data    KVMap // map with mutex lock
-	DataPre KVMap
+	DataPre KVMap // copies of the data of the Replicas-1 predecessors
+
+	Replicas    int         // copies of each key in the ring, the owner's included
+	Consistency Consistency // level of Put, Get and Delete
+	replicaList []string
+	replicaLo   *big.Int // of the replica range found by the last dropStrayCopies()
 
 	FingerIndex int
 	ON          bool
+
+	cfg       Config
+	ring      *big.Int // 2^M
+	transport Transport
+	clock     Clock
+	log       *slog.Logger
+	metrics   *nodeMetrics
+	repaired  repairStats
+	merkle    merkleCache
 }
 
-// define lookup type
+// define lookup type, a lookup fails after Config.FailTimes hops or once its Deadline
+// (zero for none) has passed
 type LookupType struct {
-	ID  *big.Int
-	cnt int
+	ID       *big.Int
+	Hops     int
+	Deadline time.Time
+}
+
+// function NewNode() returns a node at addr which talks to other nodes through transport,
+// with the defaults for the zero fields of cfg
+func NewNode(addr string, transport Transport, cfg Config) *Node {
+	cfg = cfg.WithDefaults()
+	err := cfg.Validate()
+	if err != nil {
+		slog.Default().With(LogNode, addr).Error("invalid config, using the default config", LogOp, "config", "err", err)
+		cfg = DefaultConfig()
+	}
+	o := new(Node)
+	o.cfg = cfg
+	o.ring = cfg.ringSize()
+	o.Addr = addr
+	o.ID = o.hash(o.Addr)
+	o.SetLogger(slog.Default())
+	o.Successor = make([]Edge, cfg.SuccessorListLen+1)
+	o.Finger = make([]Edge, cfg.M+1)
+	o.Data.store = NewMemStorage()
+	o.DataPre.store = NewMemStorage()
+	o.Replicas = cfg.Replicas
+	o.Consistency = cfg.Consistency
+	o.transport = meteredTransport{vnodeTransport{transport}, o}
+	o.SetMetrics(metrics.Default)
+	o.clock = realClock{}
+	return o
 }
 
-// method init() initialize the node
-func (o *Node) Init(port string) {
-	o.Addr = GetLocalAddress() + ":" + port
-	o.ID = hashString(o.Addr)
-	o.Data.Map = make(map[string]string)
-	o.DataPre.Map = make(map[string]string)
+// method Config() returns the parameters of the node
+func (o *Node) Config() Config {
+	return o.cfg
+}
+
+// method hash() returns the ID of a Key or an address on the ring of the node
+func (o *Node) hash(str string) *big.Int {
+	return new(big.Int).Mod(hashString(str), o.ring)
+}
+
+// method SetClock() replaces the wall clock, e.g. by the virtual clock of a simulated network
+func (o *Node) SetClock(clock Clock) {
+	o.clock = clock
+}
+
+// method SetStorage() replaces the in-memory storage of Data and DataPre,
+// e.g. by FileStorage so that a restarted node keeps its keys. Call it before Serve()
+func (o *Node) SetStorage(data, dataPre Storage) {
+	o.Data.store = data
+	o.DataPre.store = dataPre
+}
+
+// method Serve() starts answering calls from other nodes
+func (o *Node) Serve() error {
+	err := o.transport.Serve(newRPCNode(o))
+	if err != nil {
+		return err
+	}
+	o.ON = true
+	return nil
+}
+
+// method Maintain() starts the background maintenance of the node
+func (o *Node) Maintain() {
+	o.clock.Go(func() { o.Stabilize(true) })
+	o.clock.Go(o.FixFingers)
+	o.clock.Go(o.CheckPredecessor)
+	o.clock.Go(o.AntiEntropy)
+	o.clock.Go(o.SweepExpired)
+}
+
+// method Stop() stops answering calls from other nodes
+func (o *Node) Stop() error {
+	o.ON = false
+	o.metrics.remove()
+	err := o.transport.Close()
+	for _, m := range []*KVMap{&o.Data, &o.DataPre} {
+		if e := m.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
 // method FindSuccessor returns an edge pointing to the successor of ID in pos
 // this method may be called by other goroutine
 func (o *Node) FindSuccessor(pos *LookupType, res *Edge) error {
-	pos.cnt++
-	if pos.cnt >= FailTimes {
-		return errors.New("Lookup failure: not found ")
-	}
-	err := o.FixSuccessors()
-	if err != nil {
+	succ, next, err := o.successorStep(pos)
+	if err != nil || next.Addr == "" {
+		*res = succ
 		return err
 	}
-	if o.Successor[1].Addr == o.Addr || pos.ID.Cmp(o.ID) == 0 {
-		*res = Edge{o.Addr, new(big.Int).Set(o.ID)}
-	} else if between(o.ID, pos.ID, o.Successor[1].ID, true) {
-		*res = Edge{o.Successor[1].Addr, new(big.Int).Set(o.Successor[1].ID)}
-	} else {
-		nextNode := o.closestPrecedingNode(pos.ID)
-		if nextNode.ID == nil {
-			fmt.Println("nextNode not found, waiting...")
-			time.Sleep(Second / 2)
-			return o.FindSuccessor(pos, res)
-		}
+	err = o.forward(next.Addr, "RPCNode.FindSuccessor", pos, res)
+	return remoteError(err)
+}
+
+// method forward() passes a recursive lookup on to the node at addr,
+// within the time left until the deadline of pos if it has one
+func (o *Node) forward(addr, method string, pos *LookupType, reply interface{}) error {
+	if pos.Deadline.IsZero() {
+		return o.transport.Call(addr, method, pos, reply)
+	}
+	left := pos.Deadline.Sub(o.clock.Now())
+	if left <= 0 {
+		return ErrTimeout
+	}
+	return callTimeout(o.transport, addr, method, pos, reply, left)
+}
 
-		if Ping(nextNode.Addr) == false {
-			fmt.Println("Error: Not connected(1)")
-			return errors.New("Not connected(1) ")
+// method successorStep() runs the part of a recursive lookup at the node: it returns the
+// successor of the ID in pos if the node knows it, otherwise the node to forward the lookup to
+func (o *Node) successorStep(pos *LookupType) (succ Edge, next Edge, err error) {
+	for {
+		pos.Hops++
+		if pos.Hops >= o.cfg.FailTimes {
+			return Edge{}, Edge{}, ErrLookupHopsExceeded
 		}
-		client, err := Dial(nextNode.Addr)
-		if err != nil {
-			fmt.Println("Error: Dialing error(1): ", err)
-			return err
+		if pos.Deadline.IsZero() == false && o.clock.Now().Before(pos.Deadline) == false {
+			return Edge{}, Edge{}, ErrTimeout
 		}
-
-		err = client.Call("RPCNode.FindSuccessor", pos, res)
+		err := o.FixSuccessors()
 		if err != nil {
-			_ = client.Close()
-			fmt.Println("Error: Find successor Calling Node.FindSuccessor:", err)
-			return err
+			return Edge{}, Edge{}, err
 		}
-		err = client.Close()
-		if err != nil {
-			fmt.Println("Error: Close client error: ", err)
-			return err
+		if o.Successor[1].Addr == o.Addr || pos.ID.Cmp(o.ID) == 0 {
+			o.metrics.hops.Observe(float64(pos.Hops - 1))
+			return Edge{o.Addr, new(big.Int).Set(o.ID)}, Edge{}, nil
+		}
+		if between(o.ID, pos.ID, o.Successor[1].ID, true) {
+			o.metrics.hops.Observe(float64(pos.Hops - 1))
+			return Edge{o.Successor[1].Addr, new(big.Int).Set(o.Successor[1].ID)}, Edge{}, nil
 		}
+		next := o.closestPrecedingNode(pos.ID)
+		if next.ID != nil {
+			return Edge{}, next, nil
+		}
+		o.logger("find_successor").Debug("no closer node known, waiting", "id", pos.ID)
+		o.clock.Sleep(Second / 2)
 	}
-	return nil
 }
 
 // method closestPrecedingNode() searches the local table for the highest predecessor of id
 func (o *Node) closestPrecedingNode(id *big.Int) Edge {
-	for i := M; i > 0; i-- {
+	for i := o.cfg.M; i > 0; i-- {
 		if o.Finger[i].ID != nil && o.Ping(o.Finger[i].Addr) {
 			if between(o.ID, o.Finger[i].ID, id, true) {
 				return Edge{o.Finger[i].Addr, new(big.Int).Set(o.Finger[i].ID)}
@@ -129,163 +228,108 @@ func (o *Node) closestPrecedingNode(id *big.Int) Edge {
 	}
 }
 
-// method Create() creates a new chord ring
+// method Create() creates a new chord ring keeping o.Replicas copies of each key
 // Note that the predecessor of the only node is itself
 func (o *Node) Create() {
+	if o.Replicas < 1 {
+		o.Replicas = 1
+	}
 	o.Predecessor = &Edge{o.Addr, new(big.Int).Set(o.ID)}
-	for i := 1; i <= successorListLen; i++ {
+	for i := 1; i <= o.cfg.SuccessorListLen; i++ {
 		o.Successor[i] = Edge{o.Addr, new(big.Int).Set(o.ID)}
 	}
 }
 
 // method Join() make a node p join the chord ring
 func (o *Node) Join(addr string) bool {
-	// client: the node which the current node joins from
-	if Ping(addr) == false {
-		fmt.Println("Error: Not connected(2)")
-		return false
-	}
-	client, err := Dial(addr)
-	if err != nil {
-		fmt.Println("Error: Dialing error(2): ", err)
-		return false
-	}
-
 	o.Predecessor = nil
-	err = client.Call("RPCNode.FindSuccessor",
-		&LookupType{new(big.Int).Set(o.ID), 0}, &o.Successor[1])
-	if err != nil {
-		_ = client.Close()
-		log.Fatalln("Error: Calling Node.FindSuccessor: ", err)
-		return false
+	var err error
+	if o.cfg.Lookup == Iterative {
+		o.Successor[1], _, err = o.iterativeFindSuccessor(context.Background(), o.ID, Edge{addr, o.hash(addr)})
+	} else {
+		err = o.transport.Call(addr, "RPCNode.FindSuccessor",
+			&LookupType{ID: new(big.Int).Set(o.ID)}, &o.Successor[1])
 	}
-	err = client.Close()
 	if err != nil {
-		fmt.Println("Error: Close client error: ", err)
+		o.logger("join").Warn("find successor failed", "peer", addr, "err", err)
 		return false
 	}
 
-	// client: the successor of the current node
-	if Ping(o.Successor[1].Addr) == false {
-		fmt.Println("Error: Not connected(3)")
-		return false
-	}
-	client, err = Dial(o.Successor[1].Addr)
+	// the replication factor is set by the ring
+	err = o.transport.Call(o.Successor[1].Addr, "RPCNode.GetReplicas", 0, &o.Replicas)
 	if err != nil {
-		fmt.Println("Error: Dialing error(3): ", err)
+		o.logger("join").Warn("get replicas failed", "peer", o.Successor[1].Addr, "err", err)
 		return false
 	}
 
-	var list [successorListLen + 1]Edge
-	err = client.Call("RPCNode.GetSuccessorList", 0, &list)
+	var list []Edge
+	err = o.transport.Call(o.Successor[1].Addr, "RPCNode.GetSuccessorList", 0, &list)
 	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Call GetSuccessorList Error", err)
+		o.logger("join").Warn("get successor list failed", "peer", o.Successor[1].Addr, "err", err)
 		return false
 	}
 	o.sLock.Lock()
-	for i := 2; i <= successorListLen; i++ {
-		o.Successor[i] = list[i-1]
-	}
+	copy(o.Successor[2:], list[1:])
 	o.sLock.Unlock()
 
-	/* ---- move k-v pairs ---- */
-	o.DataPre.lock.Lock()
-	err = client.Call("RPCNode.MoveDataPre", 0, &o.DataPre.Map)
-	o.DataPre.lock.Unlock()
+	/* ---- move k-v pairs, except those kept from before a restart ---- */
+	dataPre := make(map[string]Siblings)
+	err = o.transport.Call(o.Successor[1].Addr, "RPCNode.MoveDataPre", MoveArgs{nil, o.DataPre.digest()}, &dataPre)
 	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: MoveDataPre", err)
+		o.logger("join").Warn("move replicas failed", "peer", o.Successor[1].Addr, "err", err)
 		return false
 	}
-
-	o.Data.lock.Lock()
-	err = client.Call("RPCNode.MoveKVPairs", new(big.Int).Set(o.ID), &o.Data.Map)
-	o.Data.lock.Unlock()
+	err = o.DataPre.merge(dataPre, o.clock.Now().UnixNano())
 	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: MoveKVPairs", err)
+		o.logger("join").Error("store replicas failed", "err", err)
 		return false
 	}
 
-	// Notify the successor of the current node
-	err = client.Call("RPCNode.Notify", &Edge{o.Addr, new(big.Int).Set(o.ID)}, new(int))
+	data := make(map[string]Siblings)
+	err = o.transport.Call(o.Successor[1].Addr, "RPCNode.MoveKVPairs", MoveArgs{new(big.Int).Set(o.ID), o.Data.digest()}, &data)
 	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Node.Notify error: ", err)
+		o.logger("join").Warn("move keys failed", "peer", o.Successor[1].Addr, "err", err)
 		return false
 	}
-
-	err = client.Close()
+	// the successor no longer has the keys: they are stored again a few times,
+	// then handed back to the successor, which still owns them until it is notified
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			o.clock.Sleep(100 * time.Millisecond)
+		}
+		err = o.Data.merge(data, o.clock.Now().UnixNano())
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
-		fmt.Println("Error: Close client error: ", err)
+		o.logger("join").Error("store keys failed, handing them back", "err", err)
+		err = o.transport.Call(o.Successor[1].Addr, "RPCNode.MergeData", data, new(int))
+		if err != nil {
+			o.logger("join").Error("hand keys back failed", "peer", o.Successor[1].Addr, "keys", len(data), "err", err)
+		}
 		return false
 	}
 
-	time.Sleep(200 * time.Millisecond)
-	return true
-}
-
-// method Quit() let the current node quit the chord ring
-// note that the current node has predecessor and successor
-func (o *Node) Quit() {
-	err := o.FixSuccessors()
+	// Notify the successor of the current node
+	err = o.transport.Call(o.Successor[1].Addr, "RPCNode.Notify", &Edge{o.Addr, new(big.Int).Set(o.ID)}, new(int))
 	if err != nil {
-		return
+		o.logger("join").Warn("notify failed", "peer", o.Successor[1].Addr, "err", err)
+		return false
 	}
 
-	if o.Successor[1].Addr == o.Addr {
-		fmt.Println("Quit success")
-		return
-	}
-	o.MoveAllDataToSuccessor()
+	o.clock.Sleep(200 * time.Millisecond)
+	return true
+}
 
-	// set the predecessor's successor
-	if Ping(o.Predecessor.Addr) == false {
-		fmt.Println("Error: Not connected(4)")
-		return
-	}
-	client, err := Dial(o.Predecessor.Addr)
-	if err != nil {
-		fmt.Println("Error: Dialing error(4): ", err)
-		return
-	}
-	err = client.Call("RPCNode.SetSuccessor", o.Successor[1], new(int))
-	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Node.SetSuccessor error: ", err)
-		return
-	}
-	err = client.Close()
-	if err != nil {
-		fmt.Println("Error: Close client error: ", err)
-		return
-	}
+// QuitTimeout bounds Quit(), so that a successor which hangs does not block the shutdown
+const QuitTimeout = 30 * time.Second
 
-	// set the successor's predecessor
-	if Ping(o.Successor[1].Addr) == false {
-		fmt.Println("Error: Not connected(5)")
-		return
-	}
-	client, err = Dial(o.Successor[1].Addr)
-	if err != nil {
-		fmt.Println("Error: Dialing error(5): ", err)
-		return
-	}
-	err = client.Call("RPCNode.SetPredecessor", *o.Predecessor, new(int))
-	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Node.SetPredecessor error: ", err)
-		return
-	}
-	err = client.Close()
-	if err != nil {
-		fmt.Println("Error: Close client error: ", err)
-		return
-	}
-
-	o.ON = false
-	fmt.Println("Quit success")
+// method Quit() makes the node leave the ring within QuitTimeout, see Leave()
+func (o *Node) Quit() error {
+	ctx, cancel := context.WithTimeout(context.Background(), QuitTimeout)
+	defer cancel()
+	return o.Leave(ctx)
 }
 
 // method Stabilize() maintain the current successor of node o
@@ -296,7 +340,7 @@ func (o *Node) Stabilize(infinite bool) {
 	} else {
 		for o.ON == true {
 			o.simpleStabilize()
-			time.Sleep(100 * time.Millisecond)
+			o.clock.Sleep(time.Duration(o.cfg.StabilizeInterval))
 		}
 	}
 }
@@ -309,29 +353,13 @@ func (o *Node) Notify(pred *Edge, res *int) error {
 	if o.Predecessor == nil || between(o.Predecessor.ID, pred.ID, o.ID, false) {
 		o.Predecessor = pred
 	}
+	// keys between the new predecessor and o are o's now,
+	// the copies of the predecessors' data are pushed by their owners
 	if oldPre != o.Predecessor && o.Predecessor != nil {
 		if o.Predecessor.Addr != o.Addr {
-			if Ping(o.Predecessor.Addr) == false {
-				return errors.New("Error: Not connected(9) ")
-			}
-			client, err := Dial(o.Predecessor.Addr)
-			if err != nil {
-				return err
-			}
-			o.DataPre.lock.Lock()
-			o.DataPre.Map = make(map[string]string)
-			err = client.Call("RPCNode.MoveDataPre", 1, &o.DataPre.Map)
-			o.DataPre.lock.Unlock()
-			err = client.Close()
-			if err != nil {
-				return err
-			}
+			o.takeOver(o.Predecessor.ID)
 		} else {
-			o.DataPre.lock.Lock()
-			o.Data.lock.Lock()
-			o.DataPre.Map = o.Data.Map
-			o.Data.lock.Unlock()
-			o.DataPre.lock.Unlock()
+			o.takeOver(nil)
 		}
 	}
 	return nil
@@ -346,33 +374,32 @@ func (o *Node) FixFingers() {
 			o.FingerIndex = 1
 		}
 
-		var lookup LookupType
 		for i := 0; i < 5; i++ {
-			lookup = LookupType{jump(o.ID, o.FingerIndex), 0}
-			err := o.FindSuccessor(&lookup, &o.Finger[o.FingerIndex])
+			finger, err := o.findSuccessor(context.Background(), jump(o.ID, o.FingerIndex, o.ring))
 			if err == nil {
+				o.Finger[o.FingerIndex] = finger
 				break
 			} else if i == 4 {
-				fmt.Println("Error: FixFingers error, quit FixFingers,", o.Addr)
+				o.logger("fix_fingers").Error("fix finger failed, stop fixing fingers", "finger", o.FingerIndex, "err", err)
 				return
 			}
-			fmt.Println("Fix finger waiting...", i)
-			time.Sleep(100 * time.Millisecond)
+			o.logger("fix_fingers").Debug("fix finger waiting", "finger", o.FingerIndex, "try", i, "err", err)
+			o.clock.Sleep(time.Duration(o.cfg.FixFingersInterval))
 		}
 
 		edge := o.Finger[o.FingerIndex]
 
 		o.FingerIndex++
-		if o.FingerIndex > M {
+		if o.FingerIndex > o.cfg.M {
 			o.FingerIndex = 1
 			continue
 		}
 
 		for {
-			if between(o.ID, jump(o.ID, o.FingerIndex), edge.ID, true) {
+			if between(o.ID, jump(o.ID, o.FingerIndex, o.ring), edge.ID, true) {
 				o.Finger[o.FingerIndex] = Edge{edge.Addr, new(big.Int).Set(edge.ID)}
 				o.FingerIndex++
-				if o.FingerIndex > M {
+				if o.FingerIndex > o.cfg.M {
 					o.FingerIndex = 1
 					break
 				}
@@ -381,7 +408,7 @@ func (o *Node) FixFingers() {
 			}
 		}
 
-		time.Sleep(100 * time.Millisecond)
+		o.clock.Sleep(time.Duration(o.cfg.FixFingersInterval))
 	}
 }
 
@@ -390,197 +417,136 @@ func (o *Node) FixFingers() {
 func (o *Node) CheckPredecessor() {
 	for o.ON == true {
 		if o.Predecessor == nil {
-			time.Sleep(100 * time.Millisecond)
+			o.clock.Sleep(time.Duration(o.cfg.CheckPredecessorInterval))
 			continue
 		}
 		if !o.Ping(o.Predecessor.Addr) {
-			fmt.Println(o.Addr, "predecessor:", o.Predecessor.Addr, "-> nil")
+			o.logger("check_predecessor").Info("predecessor failed", "peer", o.Predecessor.Addr)
 			o.Predecessor = nil
 
+			// the data of the failed predecessor is taken over when the next
+			// predecessor notifies, unless the current node is left alone
 			_ = o.FixSuccessors()
-			if o.Successor[1].Addr != o.Addr {
-				if !Ping(o.Successor[1].Addr) {
-					fmt.Println("Error: Not connected(10)")
-					continue
-				}
-				client, err := Dial(o.Successor[1].Addr)
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-				o.DataPre.lock.Lock()
-				o.Data.lock.Lock()
-				for k, v := range o.DataPre.Map {
-					o.Data.Map[k] = v
-					err = client.Call("RPCNode.PutValueDataPre", KVPair{k, v}, new(bool))
-					if err != nil {
-						o.Data.lock.Unlock()
-						o.DataPre.lock.Unlock()
-						_ = client.Close()
-						fmt.Println(err)
-					}
-				}
-				o.DataPre.Map = make(map[string]string)
-				o.Data.lock.Unlock()
-				o.DataPre.lock.Unlock()
-			} else {
-				o.DataPre.lock.Lock()
-				o.Data.lock.Lock()
-				for k, v := range o.DataPre.Map {
-					o.Data.Map[k] = v
-				}
-				o.DataPre.Map = make(map[string]string)
-				o.Data.lock.Unlock()
-				o.DataPre.lock.Unlock()
+			if o.Successor[1].Addr == o.Addr {
+				o.takeOver(nil)
 			}
 		}
-		time.Sleep(100 * time.Millisecond)
+		o.clock.Sleep(time.Duration(o.cfg.CheckPredecessorInterval))
 	}
 }
 
 // put a Key into the chord ring
-func (o *Node) Put(key, value string) bool {
-	time.Sleep(15 * time.Millisecond)
-	keyID := hashString(key)
+func (o *Node) Put(ctx context.Context, key string, value []byte) error {
+	return o.PutLevel(ctx, key, value, o.Consistency)
+}
 
-	var res Edge
-	err := o.FindSuccessor(&LookupType{new(big.Int).Set(keyID), 0}, &res)
-	if err != nil {
-		fmt.Println("Error: Put error: ", err)
-		return false
-	}
+// put a Key into the chord ring, waiting for as many replicas as level requires.
+// The new version supersedes every version of the Key the writing replica has seen.
+func (o *Node) PutLevel(ctx context.Context, key string, value []byte, level Consistency) error {
+	return o.put(ctx, KVPair{Key: key, Value: value}, level)
+}
 
-	if Ping(res.Addr) == false {
-		fmt.Println("Error: Not connected(6)")
-		return false
-	}
-	client, err := Dial(res.Addr)
-	if err != nil {
-		fmt.Println("Error: Dialing error(6): ", err)
-		return false
-	}
+// method put() puts a pair, see PutLevel()
+func (o *Node) put(ctx context.Context, kv KVPair, level Consistency) (err error) {
+	defer func(start time.Time) { o.observe("put", start, err) }(o.clock.Now())
+	o.clock.Sleep(15 * time.Millisecond)
 
-	var success bool
-	err = client.Call("RPCNode.PutValue", KVPair{key, value}, &success)
-	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Calling Node.PutValue: ", err)
-		return false
-	}
-	err = client.Call("RPCNode.PutValueSuccessor", KVPair{key, value}, new(bool))
+	return o.retryOwner(ctx, func() error {
+		_, targets, err := o.lookupReplicas(ctx, kv.Key)
+		if err != nil {
+			return err
+		}
+		_, err = o.writeVersion(ctx, kv.Key, targets, level, "RPCNode.PutValue", kv)
+		return err
+	})
+}
+
+// get a Key, ErrNotFound if it does not exist
+func (o *Node) Get(ctx context.Context, key string) ([]byte, error) {
+	return o.GetLevel(ctx, key, o.Consistency)
+}
+
+// get a Key, reading as many replicas as level requires.
+// Conflicting versions are resolved by Siblings.Resolve(), a Key whose delete wins is not found
+func (o *Node) GetLevel(ctx context.Context, key string, level Consistency) (value []byte, err error) {
+	defer func(start time.Time) { o.observe("get", start, err) }(o.clock.Now())
+	versions, err := o.getVersions(ctx, key, level)
 	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Calling Node.PutValueSuccessor: ", err)
-		return false
+		return nil, err
 	}
-	err = client.Close()
-	if err != nil {
-		fmt.Println("Error: Close client error: ", err)
-		return false
+	value, found := versions.Resolve()
+	if found == false {
+		return nil, ErrNotFound
 	}
+	return value, nil
+}
 
-	fmt.Println("Put at", res.Addr, ": Key =", key, "Value =", value)
-	return success
+// get the live versions of a Key, more than one if they were written concurrently
+func (o *Node) GetVersions(ctx context.Context, key string, level Consistency) (Siblings, error) {
+	versions, err := o.getVersions(ctx, key, level)
+	return versions.Live(), err
 }
 
-// get a Key
-func (o *Node) Get(key string) (string, bool) {
-	time.Sleep(15 * time.Millisecond)
-	keyID := hashString(key)
+// method getVersions() reads the versions of a Key, retrying until a live one is found.
+// It returns ErrNotFound if there is none, or the last error if no read succeeded
+func (o *Node) getVersions(ctx context.Context, key string, level Consistency) (Siblings, error) {
+	o.clock.Sleep(15 * time.Millisecond)
 
+	lastErr := ErrNotFound
 	for i := 0; i < 5; i++ {
-		var res Edge
-		err := o.FindSuccessor(&LookupType{new(big.Int).Set(keyID), 0}, &res)
-		if err != nil {
-			fmt.Println("Error: Get error: ", err)
-			time.Sleep(200 * time.Millisecond)
-			continue
+		if i > 0 {
+			trace.From(ctx).Retry()
+			err := o.sleepContext(ctx, 200*time.Millisecond)
+			if err != nil {
+				return nil, err
+			}
 		}
-
-		if Ping(res.Addr) == false {
-			fmt.Println("Error: Not connected(7)")
-			time.Sleep(200 * time.Millisecond)
+		_, targets, err := o.lookupReplicas(ctx, key)
+		if err != nil {
+			lastErr = err
 			continue
 		}
-		client, err := Dial(res.Addr)
+		versions, err := o.readReplicas(ctx, key, targets, level)
 		if err != nil {
-			fmt.Println("Error: Dialing error(7): ", err)
-			time.Sleep(200 * time.Millisecond)
+			lastErr = err
 			continue
 		}
-
-		var value string
-		err = client.Call("RPCNode.GetValue", key, &value)
-		if err != nil {
-			err = client.Close()
-			if err != nil {
-				fmt.Println("Error: Close client error: ", err)
-				time.Sleep(200 * time.Millisecond)
-				continue
-			}
-
-			//fmt.Println("Get not found at", res.Addr, ": Key =", key)
-			time.Sleep(200 * time.Millisecond)
+		if len(versions.Live()) == 0 {
+			lastErr = ErrNotFound
 			continue
 		}
-
-		_ = client.Close()
-
-		fmt.Println("Get at", res.Addr, ": Key =", key, "Value =", value)
-		return value, true
+		return versions, nil
 	}
-
-	fmt.Println("Get not found: Key =", key)
-	return "", false
+	return nil, lastErr
 }
 
-// delete a Key
-func (o *Node) Delete(key string) bool {
-	time.Sleep(15 * time.Millisecond)
-	keyID := hashString(key)
-
-	var res Edge
-	err := o.FindSuccessor(&LookupType{new(big.Int).Set(keyID), 0}, &res)
-	if err != nil {
-		fmt.Println("Error: Delete error: ", err)
-		return false
-	}
+// delete a Key, ErrNotFound if it does not exist
+func (o *Node) Delete(ctx context.Context, key string) error {
+	return o.DeleteLevel(ctx, key, o.Consistency)
+}
 
-	if Ping(res.Addr) == false {
-		fmt.Println("Error: Not connected(8)")
-		return false
-	}
-	client, err := Dial(res.Addr)
-	if err != nil {
-		fmt.Println("Error: Dialing error(8): ", err)
-		return false
-	}
+// delete a Key, waiting for as many replicas as level requires.
+// The Key is kept as a tombstone so that older copies of it do not come back
+func (o *Node) DeleteLevel(ctx context.Context, key string, level Consistency) (err error) {
+	defer func(start time.Time) { o.observe("delete", start, err) }(o.clock.Now())
+	o.clock.Sleep(15 * time.Millisecond)
 
-	var success bool
-	err = client.Call("RPCNode.DeleteValue", key, &success)
-	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Calling Node.DeleteValue: ", err)
-		return false
-	}
-	err = client.Call("RPCNode.DeleteValueSuccessor", key, new(bool))
-	if err != nil {
-		_ = client.Close()
-		fmt.Println("Error: Calling Node.DeleteValueSuccessor: ", err)
-		return false
-	}
-	err = client.Close()
+	var reply WriteReply
+	err = o.retryOwner(ctx, func() error {
+		_, targets, err := o.lookupReplicas(ctx, key)
+		if err != nil {
+			return err
+		}
+		reply, err = o.writeVersion(ctx, key, targets, level, "RPCNode.DeleteValue", key)
+		return err
+	})
 	if err != nil {
-		fmt.Println("Error: Close client error: ", err)
-		return false
+		return err
 	}
-
-	if success == true {
-		fmt.Println("Delete success at", res.Addr, ": Key =", key)
-		return true
+	if reply.Found == false {
+		return ErrNotFound
 	}
-	fmt.Println("Delete not found at", res.Addr, ": Key =", key)
-	return false
+	return nil
 }
 
 // method Dump()
@@ -588,6 +554,7 @@ func (o *Node) Dump() {
 	fmt.Println("---------- DUMP ----------")
 	fmt.Println("Addr:", o.Addr)
 	fmt.Println("ID:", o.ID)
+	fmt.Println("Replicas:", o.Replicas)
 	fmt.Println("Successor:", o.Successor)
 	fmt.Println("Finger Table:", o.Finger)
 
@@ -597,11 +564,7 @@ func (o *Node) Dump() {
 		fmt.Println("Predecessor:", o.Predecessor)
 	}
 
-	o.Data.lock.Lock()
-	fmt.Println("K-V pairs:", o.Data.Map)
-	o.Data.lock.Unlock()
-	o.DataPre.lock.Lock()
-	fmt.Println("DataPre  :", o.DataPre.Map)
-	o.DataPre.lock.Unlock()
+	fmt.Println("K-V pairs:", o.Data.copy())
+	fmt.Println("DataPre  :", o.DataPre.copy())
 	fmt.Println("-------- DUMP END --------")
 }
@@ -0,0 +1,230 @@
+// N-way replication of the data of a node over its successor list
+
+package chord
+
+import (
+	"errors"
+	"math/big"
+	"slices"
+)
+
+// DefaultReplicas is the number of copies of each key (the owner's included)
+// kept by a ring unless another number is chosen before Create()
+const DefaultReplicas = 2
+
+// method replicaSet() returns the addresses of the first Replicas-1 live successors
+// on distinct hosts, which keep the copies of the current node's data
+func (o *Node) replicaSet() []string {
+	var res []string
+	seen := map[string]bool{hostOf(o.Addr): true}
+	o.sLock.Lock()
+	list := append([]Edge(nil), o.Successor...)
+	o.sLock.Unlock()
+	for i := 1; i < len(list) && len(res) < o.Replicas-1; i++ {
+		addr := list[i].Addr
+		if addr == "" || seen[hostOf(addr)] {
+			continue
+		}
+		seen[hostOf(addr)] = true
+		if o.Ping(addr) {
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
+// method replicate() copies data to the DataPre of every replica
+// it only fails if no replica could be reached
+func (o *Node) replicate(data map[string]Siblings) error {
+	var lastErr error
+	replicas := o.replicaSet()
+	failed := 0
+	for _, addr := range replicas {
+		err := o.transport.Call(addr, "RPCNode.ReplicateData", data, new(int))
+		if err != nil {
+			o.logger("replicate").Warn("replicate failed", "peer", addr, "err", err)
+			lastErr = err
+			failed++
+		}
+	}
+	if failed > 0 && failed == len(replicas) {
+		return lastErr
+	}
+	return nil
+}
+
+// method checkReplicas() re-replicates the data when the replica set changes
+// called after each stabilization. A new replica which fails to take the data is
+// left out of replicaList, so that it is tried again at the next call
+func (o *Node) checkReplicas() {
+	replicas := o.replicaSet()
+	if slices.Equal(replicas, o.replicaList) {
+		return
+	}
+	old := make(map[string]bool)
+	for _, addr := range o.replicaList {
+		old[addr] = true
+	}
+	data := o.Data.copy()
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+
+	var kept []string
+	for _, addr := range replicas {
+		if old[addr] {
+			delete(old, addr)
+			kept = append(kept, addr)
+			continue
+		}
+		if len(data) > 0 {
+			err := o.transport.Call(addr, "RPCNode.ReplicateData", data, new(int))
+			if err != nil {
+				o.logger("replicate").Warn("re-replicate failed", "peer", addr, "err", err)
+				continue
+			}
+		}
+		kept = append(kept, addr)
+	}
+	// nodes which are no longer replicas drop their copies, those which cannot
+	// be reached drop them in dropStrayCopies()
+	for addr := range old {
+		if len(keys) > 0 {
+			_ = o.transport.Call(addr, "RPCNode.RemoveDataPre", keys, new(int))
+		}
+	}
+	o.replicaList = kept
+}
+
+// method replicaRange() returns lo such that the current node is a replica of no Key
+// outside (lo, o]. An owner copies its Keys to its first Replicas-1 successors on hosts
+// other than its own, so the farthest owner is the first predecessor by which Replicas hosts
+// other than the node's were seen, one of which may be the owner's, or the last predecessor
+// a successor list reaches. lo is the predecessor of that owner, nil for the whole ring
+func (o *Node) replicaRange() (*big.Int, error) {
+	if o.Predecessor == nil {
+		return nil, errors.New("replicaRange: predecessor not found ")
+	}
+	hosts := make(map[string]bool)
+	cur := *o.Predecessor
+	for i := 1; ; i++ {
+		if cur.Addr == o.Addr {
+			return nil, nil
+		}
+		if hostOf(cur.Addr) != hostOf(o.Addr) {
+			hosts[hostOf(cur.Addr)] = true
+		}
+		var prev Edge
+		err := o.transport.Call(cur.Addr, "RPCNode.GetPredecessor", 0, &prev)
+		if err != nil {
+			return nil, err
+		}
+		if len(hosts) >= o.Replicas || i >= o.cfg.SuccessorListLen {
+			return prev.ID, nil
+		}
+		cur = prev
+	}
+}
+
+// method dropStrayCopies() drops the copies in DataPre of the Keys outside the replica range
+// of the current node, such as those of a predecessor which got a closer replica by a join.
+// The range must be the same in two calls in a row, so that a ring still stabilizing
+// does not lose copies, and the number of copies dropped is returned
+func (o *Node) dropStrayCopies() int {
+	lo, err := o.replicaRange()
+	if err != nil {
+		o.logger("replicate").Debug("replica range not found", "err", err)
+		o.replicaLo = nil
+		return 0
+	}
+	stable := lo != nil && o.replicaLo != nil && lo.Cmp(o.replicaLo) == 0
+	o.replicaLo = lo
+	if stable == false {
+		return 0
+	}
+
+	var stray []string
+	o.DataPre.lock.Lock()
+	o.DataPre.store.Range(func(k string, v Siblings) {
+		if between(lo, o.hash(k), o.ID, true) == false {
+			stray = append(stray, k)
+		}
+	})
+	o.DataPre.lock.Unlock()
+	err = o.RemoveDataPre(stray, new(int))
+	if err != nil {
+		o.logger("replicate").Error("drop stray copies failed", "err", err)
+	}
+	return len(stray)
+}
+
+// method takeOver() moves the keys in (pred, o] from DataPre to Data,
+// which happens after the predecessor failed, and replicates them
+// pred == nil means the whole ring, i.e. the current node is alone
+func (o *Node) takeOver(pred *big.Int) {
+	moved := make(map[string]Siblings)
+	o.DataPre.lock.Lock()
+	o.Data.lock.Lock()
+	var taken []string
+	o.DataPre.store.Range(func(k string, v Siblings) {
+		if pred == nil || between(pred, o.hash(k), o.ID, true) {
+			taken = append(taken, k)
+		}
+	})
+	for _, k := range taken {
+		old := o.Data.store.Get(k)
+		merged := mergeVersions(old, o.DataPre.store.Get(k))
+		if !sameVersions(merged, old) {
+			err := o.Data.set(k, merged)
+			if err != nil { // the copy is kept in DataPre, to be taken over again
+				o.logger("replicate").Error("take over failed", "err", err)
+				continue
+			}
+			moved[k] = merged
+		}
+		err := o.DataPre.remove(k)
+		if err != nil {
+			o.logger("replicate").Error("take over failed", "err", err)
+		}
+	}
+	o.Data.lock.Unlock()
+	o.DataPre.lock.Unlock()
+
+	if len(moved) > 0 && pred != nil {
+		err := o.replicate(moved)
+		if err != nil {
+			o.logger("replicate").Warn("replicate taken over keys failed", "err", err)
+		}
+	}
+}
+
+// method ReplicateData() merges copies of another node's data into DataPre
+func (o *Node) ReplicateData(data map[string]Siblings, res *int) error {
+	err := o.DataPre.merge(data, o.clock.Now().UnixNano())
+	*res = len(data)
+	return err
+}
+
+// method RemoveDataPre() drops copies of another node's data
+func (o *Node) RemoveDataPre(keys []string, res *int) error {
+	o.DataPre.lock.Lock()
+	defer o.DataPre.lock.Unlock()
+	for _, k := range keys {
+		err := o.DataPre.remove(k)
+		if err != nil {
+			return err
+		}
+	}
+	*res = len(keys)
+	return nil
+}
+
+// method GetReplicas() returns the replication factor of the ring
+func (o *Node) GetReplicas(args int, res *int) error {
+	if o.Replicas < 1 {
+		return errors.New("GetReplicas: replication factor not set ")
+	}
+	*res = o.Replicas
+	return nil
+}
@@ -4,16 +4,30 @@ package chord
 
 import (
 	"math/big"
-	"net"
+	"sync/atomic"
 )
 
+// RPCNode answers the calls to a node. The node it answers for is swapped atomically,
+// as a vnode added again to its host takes over the RPCNode served for it before
 type RPCNode struct {
-	O      *Node
-	Listen net.Listener
+	n atomic.Pointer[Node]
+}
+
+// function newRPCNode() returns the RPCNode answering for o
+func newRPCNode(o *Node) *RPCNode {
+	r := new(RPCNode)
+	r.n.Store(o)
+	return r
+}
+
+// method node() returns the node the RPCNode answers for
+func (o *RPCNode) node() *Node {
+	return o.n.Load()
 }
 
 /* method used for rpc call:
     FindSuccessor
+    NextHop
     Notify
     GetData
 	GetValue
@@ -22,70 +36,238 @@ type RPCNode struct {
     SetPredecessor
 */
 
+// method live() returns the node the RPCNode answers for, or ErrStopped once it stopped:
+// the host of a vnode which left or stopped keeps serving its RPCNode, whose state is stale
+func (o *RPCNode) live() (*Node, error) {
+	n := o.node()
+	if n.ON == false {
+		return nil, ErrStopped
+	}
+	return n, nil
+}
+
+// method Alive() fails once the node stopped, which tells the vnodes of a running host apart
+func (o *RPCNode) Alive(args int, res *bool) error {
+	_, err := o.live()
+	if err != nil {
+		return err
+	}
+	*res = true
+	return nil
+}
+
 func (o *RPCNode) FindSuccessor(pos *LookupType, res *Edge) error {
-	return o.O.FindSuccessor(pos, res)
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.FindSuccessor(pos, res)
+}
+
+func (o *RPCNode) TraceSuccessor(pos *LookupType, res *TracedEdge) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.TraceSuccessor(pos, res)
+}
+
+func (o *RPCNode) NextHop(id *big.Int, res *HopReply) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.NextHop(id, res)
 }
 
 func (o *RPCNode) Notify(pred *Edge, res *int) error {
-	return o.O.Notify(pred, res)
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.Notify(pred, res)
+}
+
+func (o *RPCNode) PutValue(kv KVPair, res *WriteReply) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.PutValue(kv, res)
+}
+
+func (o *RPCNode) GetValue(key string, res *Siblings) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.GetValue(key, res)
+}
+
+func (o *RPCNode) DeleteValue(key string, res *WriteReply) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.DeleteValue(key, res)
+}
+
+func (o *RPCNode) CondWriteValue(cw CondWrite, res *WriteReply) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.CondWriteValue(cw, res)
+}
+
+func (o *RPCNode) PutValues(pairs []KVPair, res *[]WriteReply) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.PutValues(pairs, res)
+}
+
+func (o *RPCNode) GetValues(keys []string, res *[]Siblings) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.GetValues(keys, res)
+}
+
+func (o *RPCNode) DeleteValues(keys []string, res *[]WriteReply) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.DeleteValues(keys, res)
+}
+
+func (o *RPCNode) MergeData(data map[string]Siblings, res *int) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.MergeData(data, res)
 }
 
-func (o *RPCNode) PutValue(kv KVPair, success *bool) error {
-	return o.O.PutValue(kv, success)
+func (o *RPCNode) GetValueDataPre(key string, res *Siblings) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.GetValueDataPre(key, res)
 }
 
-func (o *RPCNode) GetValue(key string, value *string) error {
-	return o.O.GetValue(key, value)
+func (o *RPCNode) GetValuesDataPre(keys []string, res *[]Siblings) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.GetValuesDataPre(keys, res)
 }
 
-func (o *RPCNode) DeleteValue(key string, success *bool) error {
-	return o.O.DeleteValue(key, success)
+func (o *RPCNode) MoveKVPairs(args MoveArgs, res *map[string]Siblings) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.MoveKVPairs(args, res)
 }
 
-func (o *RPCNode) PutValueSuccessor(kv KVPair, success *bool) error {
-	return o.O.PutValueSuccessor(kv, success)
+func (o *RPCNode) MoveDataPre(args MoveArgs, res *map[string]Siblings) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.MoveDataPre(args, res)
 }
 
-func (o *RPCNode) DeleteValueSuccessor(key string, success *bool) error {
-	return o.O.DeleteValueSuccessor(key, success)
+func (o *RPCNode) HandOff(args HandOffArgs, res *HandOffReply) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.HandOff(args, res)
 }
 
-func (o *RPCNode) PutValueDataPre(kv KVPair, success *bool) error {
-	return o.O.PutValueDataPre(kv, success)
+func (o *RPCNode) ReplicateData(data map[string]Siblings, res *int) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.ReplicateData(data, res)
 }
 
-func (o *RPCNode) DeleteValueDataPre(key string, success *bool) error {
-	return o.O.DeleteValueDataPre(key, success)
+func (o *RPCNode) RemoveDataPre(keys []string, res *int) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.RemoveDataPre(keys, res)
 }
 
-func (o *RPCNode) MoveKVPairs(newNode *big.Int, res *map[string]string) error {
-	return o.O.MoveKVPairs(newNode, res)
+func (o *RPCNode) GetReplicas(args int, res *int) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.GetReplicas(args, res)
 }
 
-func (o *RPCNode) MoveDataPre(args int, res *map[string]string) error {
-	return o.O.MoveDataPre(args, res)
+func (o *RPCNode) GetReplicaSet(args int, res *[]string) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.GetReplicaSet(args, res)
 }
 
-func (o *RPCNode) QuitMoveData(Data KVMap, res *int) error {
-	return o.O.QuitMoveData(Data, res)
+func (o *RPCNode) MerkleHashes(args MerkleArgs, res *[][]byte) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.MerkleHashes(args, res)
 }
 
-func (o *RPCNode) QuitMoveDataPre(DataPre KVMap, res *int) error {
-	return o.O.QuitMoveDataPre(DataPre, res)
+func (o *RPCNode) MerkleBuckets(args MerkleArgs, res *map[string]Siblings) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.MerkleBuckets(args, res)
 }
 
 func (o *RPCNode) GetPredecessor(args int, res *Edge) error {
-	return o.O.GetPredecessor(args, res)
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.GetPredecessor(args, res)
 }
 
-func (o *RPCNode) GetSuccessorList(args int, res *[successorListLen + 1]Edge) error {
-	return o.O.GetSuccessorList(args, res)
+func (o *RPCNode) GetSuccessorList(args int, res *[]Edge) error {
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.GetSuccessorList(args, res)
 }
 
 func (o *RPCNode) SetSuccessor(edge Edge, res *int) error {
-	return o.O.SetSuccessor(edge, res)
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.SetSuccessor(edge, res)
 }
 
 func (o *RPCNode) SetPredecessor(edge Edge, res *int) error {
-	return o.O.SetPredecessor(edge, res)
+	n, err := o.live()
+	if err != nil {
+		return err
+	}
+	return n.SetPredecessor(edge, res)
 }
@@ -0,0 +1,119 @@
+package chord_test
+
+import (
+	"chord"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"metrics"
+	"reflect"
+	"simnet"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// function churn() runs joins, puts, quits and failures of n hosts on a simulated
+// network seeded with seed. It fails t if a key is lost, and returns the virtual time the run
+// took and the ring it left, which only depend on seed. The run goes on in a goroutine of
+// the clock, so it reports errors with t.Error and returns
+func churn(t *testing.T, seed int64, n int) (time.Duration, []string) {
+	network := simnet.New(seed)
+	clock := network.Clock()
+	r := rand.New(rand.NewSource(seed))
+	reg := metrics.NewRegistry()
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := chord.DefaultConfig()
+	cfg.Replicas = 3
+
+	newHost := func(i int) *chord.Host {
+		addr := fmt.Sprintf("10.0.0.%d:2000", i)
+		h := chord.NewHost(addr, network.Endpoint(addr), 2, cfg)
+		h.SetLogger(discard)
+		h.SetMetrics(reg)
+		h.Setup = func(o *chord.Node, i int) {
+			o.SetClock(clock)
+		}
+		err := h.Serve()
+		if err != nil {
+			t.Error("serve:", err)
+		}
+		return h
+	}
+	ctx := context.Background()
+	pick := func(hosts []*chord.Host) *chord.Node {
+		h := hosts[r.Intn(len(hosts))]
+		return h.Nodes[r.Intn(len(h.Nodes))]
+	}
+	var ring []string
+	clock.Run(func() {
+		hosts := []*chord.Host{newHost(0)}
+		hosts[0].Create()
+		for i := 1; i < n; i++ {
+			h := newHost(i)
+			if h.Join(pick(hosts).Addr) == false {
+				t.Error("join failed, seed", seed)
+				return
+			}
+			hosts = append(hosts, h)
+			clock.Sleep(100 * time.Millisecond)
+		}
+		clock.Sleep(5 * time.Second)
+
+		var keys []string
+		for i := 0; i < 3*n; i++ {
+			k := strconv.Itoa(i)
+			keys = append(keys, k)
+			err := pick(hosts).Put(ctx, k, []byte(k))
+			if err != nil {
+				t.Error("put", k, err, "seed", seed)
+				return
+			}
+		}
+
+		// a host quits, another one fails, and a new one joins
+		p := 1 + r.Intn(len(hosts)-1)
+		err := hosts[p].Quit()
+		if err != nil {
+			t.Error("quit:", err, "seed", seed)
+			return
+		}
+		_ = hosts[p].Stop()
+		hosts = append(hosts[:p], hosts[p+1:]...)
+		clock.Sleep(5 * time.Second)
+		p = 1 + r.Intn(len(hosts)-1)
+		_ = hosts[p].Stop()
+		hosts = append(hosts[:p], hosts[p+1:]...)
+		clock.Sleep(5 * time.Second)
+		hosts = append(hosts, newHost(n))
+		hosts[len(hosts)-1].Join(hosts[0].Addr)
+		clock.Sleep(5 * time.Second)
+
+		for _, k := range keys {
+			res, err := pick(hosts).Get(ctx, k)
+			if err != nil || string(res) != k {
+				t.Error("get", k, "=", string(res), err, "seed", seed)
+				return
+			}
+		}
+		for _, h := range hosts {
+			for _, o := range h.Nodes {
+				ring = append(ring, o.Addr+" -> "+o.Successor[1].Addr)
+			}
+		}
+	})
+	return clock.Now().Sub(time.Unix(0, 0)), ring
+}
+
+func TestChurnDeterministic(t *testing.T) {
+	took, ring := churn(t, 5, 8)
+	took2, ring2 := churn(t, 5, 8)
+	if t.Failed() {
+		return
+	}
+	if took != took2 || reflect.DeepEqual(ring, ring2) == false {
+		t.Fatalf("two runs with seed 5 differ: %v %v, %v %v", took, ring, took2, ring2)
+	}
+}
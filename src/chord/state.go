@@ -0,0 +1,54 @@
+// state of a node for inspection, e.g. by an admin API
+
+package chord
+
+// PeerState is an Edge with its ID in decimal, which JSON numbers cannot hold
+type PeerState struct {
+	Addr string `json:"addr"`
+	ID   string `json:"id"`
+}
+
+// NodeState is a snapshot of the ring state and keys of a node
+type NodeState struct {
+	Addr        string      `json:"addr"`
+	ID          string      `json:"id"`
+	ON          bool        `json:"on"`
+	Predecessor *PeerState  `json:"predecessor"`
+	Successors  []PeerState `json:"successors"`
+	Fingers     []PeerState `json:"fingers"`  // the distinct fingers, from Finger[1] on
+	Replicas    []string    `json:"replicas"` // the nodes holding copies of its keys
+	Keys        int         `json:"keys"`
+	KeysPre     int         `json:"keys_pre"`
+	Repaired    int         `json:"repaired"` // by anti-entropy, since the node started
+}
+
+// function peerState() returns the state of e, nil if e is not set
+func peerState(e *Edge) *PeerState {
+	if e == nil || e.ID == nil {
+		return nil
+	}
+	return &PeerState{e.Addr, e.ID.String()}
+}
+
+// method State() returns a snapshot of the state of the node
+func (o *Node) State() NodeState {
+	res := NodeState{Addr: o.Addr, ID: o.ID.String(), ON: o.ON, Predecessor: peerState(o.Predecessor)}
+	o.sLock.Lock()
+	for i := 1; i < len(o.Successor); i++ {
+		if p := peerState(&o.Successor[i]); p != nil {
+			res.Successors = append(res.Successors, *p)
+		}
+	}
+	o.sLock.Unlock()
+	for i := 1; i < len(o.Finger); i++ {
+		p := peerState(&o.Finger[i])
+		if p != nil && (len(res.Fingers) == 0 || res.Fingers[len(res.Fingers)-1].Addr != p.Addr) {
+			res.Fingers = append(res.Fingers, *p)
+		}
+	}
+	res.Replicas = o.replicaSet()
+	res.Keys = o.Data.len()
+	res.KeysPre = o.DataPre.len()
+	_, res.Repaired = o.Repaired()
+	return res
+}
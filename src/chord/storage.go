@@ -0,0 +1,202 @@
+// storage backends of the data of a node
+
+package chord
+
+import "fmt"
+
+// Storage keeps the versions of the Keys of a KVMap.
+// A KVMap serializes the calls, so an implementation needs no locking of its own
+type Storage interface {
+	Get(key string) Siblings
+	Set(key string, versions Siblings) error
+	Delete(key string) error
+	// Range calls f for every Key, f must not call the Storage
+	Range(f func(key string, versions Siblings))
+	Len() int
+	Close() error
+}
+
+// memStorage is the in-memory Storage, lost on exit
+type memStorage map[string]Siblings
+
+// function NewMemStorage() returns an empty in-memory Storage
+func NewMemStorage() Storage {
+	return make(memStorage)
+}
+
+func (s memStorage) Get(key string) Siblings {
+	return s[key]
+}
+
+func (s memStorage) Set(key string, versions Siblings) error {
+	s[key] = versions
+	return nil
+}
+
+func (s memStorage) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func (s memStorage) Range(f func(key string, versions Siblings)) {
+	for k, v := range s {
+		f(k, v)
+	}
+}
+
+func (s memStorage) Len() int {
+	return len(s)
+}
+
+func (s memStorage) Close() error {
+	return nil
+}
+
+// method set() stores the versions of a key, the lock being held
+func (m *KVMap) set(key string, versions Siblings) error {
+	m.writes++
+	err := m.store.Set(key, versions)
+	if err != nil {
+		return fmt.Errorf("%wkey %s: %v", ErrStorage, key, err)
+	}
+	return nil
+}
+
+// method remove() removes a key, the lock being held
+func (m *KVMap) remove(key string) error {
+	m.writes++
+	err := m.store.Delete(key)
+	if err != nil {
+		return fmt.Errorf("%wkey %s: %v", ErrStorage, key, err)
+	}
+	return nil
+}
+
+// method merge() merges versions of keys into the map, the versions expired at now
+// becoming tombstones. It stops at the first key the storage fails to write
+func (m *KVMap) merge(data map[string]Siblings, now int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for k, v := range data {
+		old := m.store.Get(k)
+		merged := mergeVersions(old, v).expire(now)
+		if len(merged) > 0 && !sameVersions(old, merged) {
+			err := m.set(k, merged)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// method sweep() replaces the versions expired at now with tombstones, see Siblings.expire(),
+// and removes the keys whose versions are all tombstones written more than grace before now.
+// It returns the number of keys which expired and of those removed
+func (m *KVMap) sweep(now, grace int64) (expired, collected int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	changed := make(map[string]Siblings)
+	var dead []string
+	m.store.Range(func(k string, v Siblings) {
+		if v.collectable(now - grace) {
+			dead = append(dead, k)
+		} else if v.hasExpired(now) {
+			changed[k] = v.expire(now)
+		}
+	})
+	for k, v := range changed {
+		err := m.set(k, v)
+		if err != nil { // swept again next time
+			m.log.Error("sweep failed", "err", err)
+			continue
+		}
+		expired++
+	}
+	for _, k := range dead {
+		err := m.remove(k)
+		if err != nil {
+			m.log.Error("sweep failed", "err", err)
+			continue
+		}
+		collected++
+	}
+	return expired, collected
+}
+
+// method get() returns the versions of a key
+func (m *KVMap) get(key string) Siblings {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.store.Get(key)
+}
+
+// method copy() returns a copy of the map
+func (m *KVMap) copy() map[string]Siblings {
+	m.lock.Lock()
+	res := make(map[string]Siblings, m.store.Len())
+	m.store.Range(func(k string, v Siblings) {
+		res[k] = v
+	})
+	m.lock.Unlock()
+	return res
+}
+
+// method written() returns the number of writes to the map so far
+func (m *KVMap) written() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.writes
+}
+
+// method digest() returns the version (VClock string) of every key
+func (m *KVMap) digest() map[string]string {
+	m.lock.Lock()
+	res := make(map[string]string, m.store.Len())
+	m.store.Range(func(k string, v Siblings) {
+		res[k] = v.clock().String()
+	})
+	m.lock.Unlock()
+	return res
+}
+
+// method mint() writes a new version of a key which descends all versions in the map,
+// id being the writing node and expire the time it expires at (0 for never).
+// found is whether a live value was replaced, an expired one being no longer live.
+// It fails with ErrStorage if the storage could not write the version
+func (m *KVMap) mint(key string, value []byte, deleted bool, id string, now, expire int64) (Versioned, bool, error) {
+	ver, found, _, err := m.mintIf(key, value, deleted, id, now, expire, nil)
+	return ver, found, err
+}
+
+// method mintIf() is mint() done only if cond holds for the current versions of the key,
+// the expired ones being tombstones
+func (m *KVMap) mintIf(key string, value []byte, deleted bool, id string, now, expire int64, cond func(Siblings) bool) (Versioned, bool, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	old := m.store.Get(key)
+	current := old.expire(now)
+	if cond != nil && !cond(current) {
+		return Versioned{}, len(current.Live()) > 0, false, nil
+	}
+	ver := Versioned{value, deleted, old.clock().Increment(id), now, expire}
+	err := m.set(key, Siblings{ver})
+	if err != nil {
+		return Versioned{}, false, false, err
+	}
+	return ver, len(current.Live()) > 0, true, nil
+}
+
+// method len() returns the number of keys of the map
+func (m *KVMap) len() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.store.Len()
+}
+
+// method close() closes the storage
+func (m *KVMap) close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.store.Close()
+}
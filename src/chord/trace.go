@@ -0,0 +1,23 @@
+// Tracing of the calls made by an operation, see package trace
+
+package chord
+
+import (
+	"context"
+	"trace"
+)
+
+// method call() calls method on the node at addr until ctx ends, recording the call in the
+// trace of ctx if any
+func (o *Node) call(ctx context.Context, addr, method string, args, reply interface{}) error {
+	err := o.checkContext(ctx)
+	if err != nil {
+		return err
+	}
+	start := o.clock.Now()
+	err = contextError(callContext(ctx, o.transport, addr, method, args, reply))
+	if t := trace.From(ctx); t != nil {
+		t.Add(addr, method, o.clock.Now().Sub(start), err)
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+// Transport abstracts how chord nodes talk to each other
+
+package chord
+
+import (
+	"context"
+	"net/rpc"
+	"rpcpool"
+	"time"
+)
+
+// Transport carries calls between chord nodes.
+// A node is constructed with one Transport and never touches the network directly.
+type Transport interface {
+	// Serve starts accepting calls for the methods of rcvr
+	Serve(rcvr interface{}) error
+	// ServeName is Serve with the methods called as "name.Method",
+	// so that one transport can serve several receivers of the same type
+	ServeName(name string, rcvr interface{}) error
+	// Close stops accepting calls
+	Close() error
+	// Call invokes method (e.g. "RPCNode.FindSuccessor") on the node at addr
+	Call(addr, method string, args, reply interface{}) error
+	// Ping checks whether the node at addr is reachable
+	Ping(addr string) bool
+}
+
+// TimeoutCaller is a Transport which bounds the time of a single call,
+// e.g. of a hop of an iterative lookup
+type TimeoutCaller interface {
+	CallTimeout(addr, method string, args, reply interface{}, timeout time.Duration) error
+}
+
+// function callTimeout() calls method within timeout if t can bound a call,
+// otherwise within the timeout of t
+func callTimeout(t Transport, addr, method string, args, reply interface{}, timeout time.Duration) error {
+	if tc, ok := t.(TimeoutCaller); ok {
+		return tc.CallTimeout(addr, method, args, reply, timeout)
+	}
+	return t.Call(addr, method, args, reply)
+}
+
+// ContextCaller is a Transport which gives up a call once a context ends,
+// e.g. the context of a Get
+type ContextCaller interface {
+	CallContext(ctx context.Context, addr, method string, args, reply interface{}) error
+}
+
+// function callContext() calls method until ctx ends if t can give up a call,
+// otherwise within the time left until the deadline of ctx, on the wall clock
+func callContext(ctx context.Context, t Transport, addr, method string, args, reply interface{}) error {
+	if cc, ok := t.(ContextCaller); ok {
+		return cc.CallContext(ctx, addr, method, args, reply)
+	}
+	if d, ok := ctx.Deadline(); ok {
+		return callTimeout(t, addr, method, args, reply, time.Until(d))
+	}
+	return t.Call(addr, method, args, reply)
+}
+
+// RPCTransport is the net/rpc over TCP implementation of Transport.
+// It keeps one connection per peer, see rpcpool
+type RPCTransport struct {
+	listenAddr string
+	server     *rpc.Server
+	listener   *rpcpool.Listener
+	pool       *rpcpool.Pool
+}
+
+// function NewRPCTransport() returns a transport which listens on listenAddr, e.g. ":2000"
+func NewRPCTransport(listenAddr string) *RPCTransport {
+	return NewPooledTransport(listenAddr, rpcpool.DefaultOptions())
+}
+
+// function NewPooledTransport() returns a transport which listens on listenAddr,
+// with its connection pool tuned by opts
+func NewPooledTransport(listenAddr string, opts rpcpool.Options) *RPCTransport {
+	return &RPCTransport{listenAddr: listenAddr, server: rpc.NewServer(), pool: rpcpool.New(opts)}
+}
+
+func (t *RPCTransport) Serve(rcvr interface{}) error {
+	err := t.server.Register(rcvr)
+	if err != nil {
+		return err
+	}
+	return t.listen()
+}
+
+func (t *RPCTransport) ServeName(name string, rcvr interface{}) error {
+	err := t.server.RegisterName(name, rcvr)
+	if err != nil {
+		return err
+	}
+	return t.listen()
+}
+
+// method listen() starts accepting connections, once
+func (t *RPCTransport) listen() error {
+	if t.listener != nil {
+		return nil
+	}
+	listener, err := rpcpool.Listen(t.server, t.listenAddr)
+	if err != nil {
+		return err
+	}
+	t.listener = listener
+	return nil
+}
+
+// method Close() stops serving, dropping the connections of other nodes, and closes the pool
+func (t *RPCTransport) Close() error {
+	err := t.pool.Close()
+	if t.listener == nil {
+		return err
+	}
+	return t.listener.Close()
+}
+
+func (t *RPCTransport) Call(addr, method string, args, reply interface{}) error {
+	return t.pool.Call(addr, method, args, reply)
+}
+
+func (t *RPCTransport) CallTimeout(addr, method string, args, reply interface{}, timeout time.Duration) error {
+	return t.pool.CallTimeout(addr, method, args, reply, timeout)
+}
+
+func (t *RPCTransport) CallContext(ctx context.Context, addr, method string, args, reply interface{}) error {
+	return t.pool.CallContext(ctx, addr, method, args, reply)
+}
+
+func (t *RPCTransport) Ping(addr string) bool {
+	return t.pool.Ping(addr)
+}
@@ -0,0 +1,63 @@
+// keys with a time to live, replaced by tombstones once expired, and the removal of old tombstones
+
+package chord
+
+import (
+	"context"
+	"time"
+)
+
+// method expireAt() returns the wall time in UnixNano a Key written now with ttl expires at
+func (o *Node) expireAt(ttl time.Duration) int64 {
+	return o.clock.Now().Add(ttl).UnixNano()
+}
+
+// put a Key which expires after ttl, after which it is read as missing.
+// The deadline is set by the node coordinating the write, so replicas agree on it
+func (o *Node) PutWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return o.PutLevelWithTTL(ctx, key, value, ttl, o.Consistency)
+}
+
+// put a Key which expires after ttl, waiting for as many replicas as level requires
+func (o *Node) PutLevelWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration, level Consistency) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return o.put(ctx, KVPair{Key: key, Value: value, Expire: o.expireAt(ttl)}, level)
+}
+
+// make an existing Key expire after ttl, ErrNotFound if it does not exist.
+// The value is rewritten only if it was not written in between, see CompareAndSwap()
+func (o *Node) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	value, version, err := o.GetWithVersion(ctx, key)
+	if err != nil {
+		return err
+	}
+	return o.condWrite(ctx, CondWrite{Key: key, Value: value, Expected: version, Expire: o.expireAt(ttl)}, o.Consistency)
+}
+
+// method SweepExpired() replaces the expired versions of Data and DataPre with tombstones
+// every SweepInterval of the config. A tombstone keeps the clock of the version it replaces,
+// so that a replica which missed the version cannot hand an older one back to the others.
+// Tombstones, of deletes as well as of expiries, are removed after the TombstoneGrace of the config
+func (o *Node) SweepExpired() {
+	grace := time.Duration(o.cfg.TombstoneGrace).Nanoseconds()
+	for o.ON == true {
+		o.clock.Sleep(time.Duration(o.cfg.SweepInterval))
+		now := o.clock.Now().UnixNano()
+		expired, collected := o.Data.sweep(now, grace)
+		expiredPre, collectedPre := o.DataPre.sweep(now, grace)
+		expired, collected = expired+expiredPre, collected+collectedPre
+		if expired > 0 {
+			o.metrics.expired.Add(float64(expired))
+			o.logger("sweep").Debug("expired keys", "keys", expired)
+		}
+		if collected > 0 {
+			o.metrics.collected.Add(float64(collected))
+			o.logger("sweep").Debug("collected tombstones", "keys", collected)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// versioned values: vector clocks, siblings and tombstones
+
+package chord
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// VClock is a vector clock, the number of writes coordinated by each node
+type VClock map[string]uint64
+
+// method Increment() returns a copy of the clock with the counter of id increased
+func (c VClock) Increment(id string) VClock {
+	res := c.Merge(nil)
+	res[id]++
+	return res
+}
+
+// method Merge() returns the least clock which descends both clocks
+func (c VClock) Merge(other VClock) VClock {
+	res := make(VClock, len(c))
+	for k, n := range c {
+		res[k] = n
+	}
+	for k, n := range other {
+		if n > res[k] {
+			res[k] = n
+		}
+	}
+	return res
+}
+
+// method Descends() returns whether c has seen every write other has seen
+func (c VClock) Descends(other VClock) bool {
+	for k, n := range other {
+		if c[k] < n {
+			return false
+		}
+	}
+	return true
+}
+
+func (c VClock) String() string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	strs := make([]string, len(keys))
+	for i, k := range keys {
+		strs[i] = k + "=" + strconv.FormatUint(c[k], 10)
+	}
+	return strings.Join(strs, ",")
+}
+
+// method Concurrent() returns whether neither clock descends the other
+func (c VClock) Concurrent(other VClock) bool {
+	return !c.Descends(other) && !other.Descends(c)
+}
+
+// Versioned is one version of a value, a deleted value is kept as a tombstone for the TombstoneGrace of the config
+type Versioned struct {
+	Value   []byte
+	Deleted bool
+	Clock   VClock
+	Time    int64 // wall time in UnixNano when written, only used to pick among siblings
+	Expire  int64 // wall time in UnixNano the version expires at, 0 if it never does
+}
+
+// method expired() returns whether the version has expired at now (UnixNano)
+func (v Versioned) expired(now int64) bool {
+	return v.Expire != 0 && v.Expire <= now
+}
+
+// Siblings are the versions of a key which no other version of the key descends
+type Siblings []Versioned
+
+// function mergeVersions() returns the versions of a and b which are not descended by another one
+func mergeVersions(a, b Siblings) Siblings {
+	all := append(append(Siblings{}, a...), b...)
+	var res Siblings
+	for i, v := range all {
+		keep := true
+		for j, w := range all {
+			if i == j {
+				continue
+			}
+			if w.Clock.Descends(v.Clock) && (!v.Clock.Descends(w.Clock) || j < i) {
+				// w is newer, or the same version seen earlier
+				keep = false
+				break
+			}
+		}
+		if keep {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// function sameVersions() returns whether a and b hold the same versions
+func sameVersions(a, b Siblings) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, v := range a {
+		found := false
+		for _, w := range b {
+			if v.Clock.Descends(w.Clock) && w.Clock.Descends(v.Clock) {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			return false
+		}
+	}
+	return true
+}
+
+// method clock() returns the clock descending every sibling
+func (s Siblings) clock() VClock {
+	res := VClock{}
+	for _, v := range s {
+		res = res.Merge(v.Clock)
+	}
+	return res
+}
+
+// method Live() returns the siblings which are not tombstones
+func (s Siblings) Live() Siblings {
+	var res Siblings
+	for _, v := range s {
+		if v.Deleted == false {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// method expire() returns the siblings with the versions expired at now (UnixNano) replaced by
+// tombstones. A tombstone keeps the clock of the version, so that an older copy of the Key held
+// by a replica does not come back, and is written at the expiry, to be ordered among siblings
+func (s Siblings) expire(now int64) Siblings {
+	var res Siblings
+	for _, v := range s {
+		if v.expired(now) {
+			v = Versioned{Deleted: true, Clock: v.Clock, Time: v.Expire}
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+// method hasExpired() returns whether a sibling has expired at now (UnixNano)
+func (s Siblings) hasExpired(now int64) bool {
+	for _, v := range s {
+		if v.expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// method collectable() returns whether all siblings are tombstones written before t (UnixNano)
+func (s Siblings) collectable(t int64) bool {
+	for _, v := range s {
+		if v.Deleted == false || v.Time >= t {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// method Resolve() merges the siblings into one value:
+// the latest written wins and ties are broken by the greater value.
+// found is false if the winner is a tombstone or there are no siblings
+func (s Siblings) Resolve() (value []byte, found bool) {
+	if len(s) == 0 {
+		return nil, false
+	}
+	best := s[0]
+	for _, v := range s[1:] {
+		if v.Time > best.Time || (v.Time == best.Time && bytes.Compare(v.Value, best.Value) > 0) {
+			best = v
+		}
+	}
+	return best.Value, !best.Deleted
+}
+
+func (s Siblings) String() string {
+	strs := make([]string, len(s))
+	for i, v := range s {
+		if v.Deleted {
+			strs[i] = fmt.Sprintf("<deleted>%v", map[string]uint64(v.Clock))
+		} else {
+			strs[i] = fmt.Sprintf("%s%v", printable(v.Value), map[string]uint64(v.Clock))
+		}
+		if v.Expire != 0 {
+			strs[i] += "<expires " + time.Unix(0, v.Expire).Format(time.RFC3339) + ">"
+		}
+	}
+	return "[" + strings.Join(strs, " ") + "]"
+}
+
+// function printable() returns value as text, or its size if it is binary or too long to print
+func printable(value []byte) string {
+	if len(value) > 64 || utf8.Valid(value) == false {
+		return fmt.Sprintf("<%d bytes>", len(value))
+	}
+	return string(value)
+}
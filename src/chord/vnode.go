@@ -0,0 +1,295 @@
+// virtual nodes: several ring positions sharing the transport of one physical node
+
+package chord
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Host is a physical node running several virtual nodes of a ring on one transport.
+// Vnode 0 is addressed as Addr and vnode i as Addr+"#i", and each vnode keeps
+// its own ID, finger table, successor list and data.
+type Host struct {
+	Addr  string
+	Nodes []*Node
+	// Setup is called on every vnode before it serves, e.g. to set its clock or storage
+	Setup func(o *Node, i int)
+
+	cfg       Config
+	root      *slog.Logger // the logger of the vnodes, without the address of the host
+	log       *slog.Logger
+	reg       *metrics.Registry
+	transport Transport
+	lock      sync.Mutex
+	rcvrs     map[string]*RPCNode
+}
+
+// function splitVnode() splits the address of a vnode into the address of its host
+// and the name its RPCNode is served as
+func splitVnode(addr string) (string, string) {
+	if i := strings.LastIndex(addr, "#"); i >= 0 {
+		return addr[:i], "RPCNode" + addr[i:]
+	}
+	return addr, "RPCNode"
+}
+
+// function hostOf() returns the address of the host of a vnode
+func hostOf(addr string) string {
+	host, _ := splitVnode(addr)
+	return host
+}
+
+// vnodeTransport lets a node call the vnodes of other hosts:
+// a call to "host#i" goes to the RPCNode served as "RPCNode#i" at host
+type vnodeTransport struct {
+	Transport
+}
+
+// function route() returns the host of a vnode and the name its method is served as
+func route(addr, method string) (string, string) {
+	host, service := splitVnode(addr)
+	if service != "RPCNode" && strings.HasPrefix(method, "RPCNode.") {
+		method = service + method[len("RPCNode"):]
+	}
+	return host, method
+}
+
+func (t vnodeTransport) Call(addr, method string, args, reply interface{}) error {
+	host, method := route(addr, method)
+	return t.Transport.Call(host, method, args, reply)
+}
+
+func (t vnodeTransport) CallTimeout(addr, method string, args, reply interface{}, timeout time.Duration) error {
+	host, method := route(addr, method)
+	return callTimeout(t.Transport, host, method, args, reply, timeout)
+}
+
+func (t vnodeTransport) CallContext(ctx context.Context, addr, method string, args, reply interface{}) error {
+	host, method := route(addr, method)
+	return callContext(ctx, t.Transport, host, method, args, reply)
+}
+
+// method Ping() checks the vnode itself, which stops while its host keeps running
+func (t vnodeTransport) Ping(addr string) bool {
+	host, service := splitVnode(addr)
+	if service == "RPCNode" {
+		return t.Transport.Ping(host)
+	}
+	return t.Transport.Call(host, service+".Alive", 0, new(bool)) == nil
+}
+
+// hostTransport is the transport of a vnode: it serves on the transport of the host
+// and leaves it open when the vnode stops
+type hostTransport struct {
+	Transport
+	host    *Host
+	service string
+}
+
+func (t *hostTransport) Serve(rcvr interface{}) error {
+	r, ok := rcvr.(*RPCNode)
+	if ok == false {
+		return errors.New("Vnodes only serve RPCNode ")
+	}
+	return t.host.serve(t.service, r)
+}
+
+func (t *hostTransport) CallTimeout(addr, method string, args, reply interface{}, timeout time.Duration) error {
+	return callTimeout(t.Transport, addr, method, args, reply, timeout)
+}
+
+func (t *hostTransport) CallContext(ctx context.Context, addr, method string, args, reply interface{}) error {
+	return callContext(ctx, t.Transport, addr, method, args, reply)
+}
+
+func (t *hostTransport) Close() error {
+	return nil
+}
+
+// function NewHost() returns a host at addr running vnodes virtual nodes on transport,
+// each with the config cfg
+func NewHost(addr string, transport Transport, vnodes int, cfg Config) *Host {
+	h := &Host{Addr: addr, cfg: cfg, transport: transport, rcvrs: make(map[string]*RPCNode)}
+	h.root = slog.Default()
+	h.log = h.root.With(LogNode, addr)
+	h.reg = metrics.Default
+	if vnodes < 1 {
+		vnodes = 1
+	}
+	for i := 0; i < vnodes; i++ {
+		h.Nodes = append(h.Nodes, h.newVnode(i))
+	}
+	return h
+}
+
+func (h *Host) newVnode(i int) *Node {
+	addr := h.Addr
+	if i > 0 {
+		addr += "#" + strconv.Itoa(i)
+	}
+	_, service := splitVnode(addr)
+	o := NewNode(addr, &hostTransport{h.transport, h, service}, h.cfg)
+	o.SetLogger(h.root)
+	o.SetMetrics(h.reg)
+	return o
+}
+
+// method SetLogger() replaces the logger of the host and of its vnodes
+func (h *Host) SetLogger(l *slog.Logger) {
+	h.root = l
+	h.log = l.With(LogNode, h.Addr)
+	for _, o := range h.Nodes {
+		o.SetLogger(l)
+	}
+}
+
+// method SetMetrics() registers the metrics of the vnodes in reg
+func (h *Host) SetMetrics(reg *metrics.Registry) {
+	h.reg = reg
+	for _, o := range h.Nodes {
+		o.SetMetrics(reg)
+	}
+}
+
+// method serve() serves the RPCNode of a vnode. net/rpc cannot drop a service,
+// so a vnode added again after it was removed takes over its old RPCNode,
+// which may be answering calls meanwhile, see RPCNode
+func (h *Host) serve(service string, r *RPCNode) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if old, ok := h.rcvrs[service]; ok {
+		old.n.Store(r.node())
+		return nil
+	}
+	h.rcvrs[service] = r
+	return h.transport.ServeName(service, r)
+}
+
+// method start() sets up a vnode and starts answering calls
+func (h *Host) start(o *Node, i int) error {
+	if h.Setup != nil {
+		h.Setup(o, i)
+	}
+	return o.Serve()
+}
+
+// method Serve() starts answering calls for every vnode
+func (h *Host) Serve() error {
+	for i, o := range h.Nodes {
+		err := h.start(o, i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// method Create() creates a ring with vnode 0 and joins the other vnodes to it
+func (h *Host) Create() bool {
+	h.Nodes[0].Create()
+	h.Nodes[0].Maintain()
+	for _, o := range h.Nodes[1:] {
+		if o.Join(h.Nodes[0].Addr) == false {
+			return false
+		}
+		o.Maintain()
+	}
+	return true
+}
+
+// method Join() joins every vnode to the ring containing addr
+func (h *Host) Join(addr string) bool {
+	for _, o := range h.Nodes {
+		if o.Join(addr) == false {
+			return false
+		}
+		o.Maintain()
+	}
+	return true
+}
+
+// method SetVnodes() changes the number of vnodes of a host in a ring:
+// new vnodes join and take their keys from their successors,
+// removed vnodes quit and hand their keys to their successors
+func (h *Host) SetVnodes(n int) bool {
+	if n < 1 {
+		h.log.Error("a host needs at least one vnode", LogOp, "set_vnodes", "vnodes", n)
+		return false
+	}
+	for len(h.Nodes) < n {
+		i := len(h.Nodes)
+		o := h.newVnode(i)
+		err := h.start(o, i)
+		if err != nil {
+			h.log.Error("serve failed", LogOp, "set_vnodes", "vnode", i, "err", err)
+			return false
+		}
+		if o.Join(h.Nodes[0].Addr) == false {
+			_ = o.Stop()
+			return false
+		}
+		o.Maintain()
+		h.Nodes = append(h.Nodes, o)
+	}
+	for len(h.Nodes) > n {
+		o := h.Nodes[len(h.Nodes)-1]
+		err := o.Quit()
+		if err != nil { // the vnode keeps its keys and stays
+			h.log.Error("quit failed", LogOp, "set_vnodes", "err", err)
+			return false
+		}
+		err = o.Stop()
+		if err != nil {
+			h.log.Error("stop failed", LogOp, "set_vnodes", "err", err)
+		}
+		h.Nodes = h.Nodes[:len(h.Nodes)-1]
+	}
+	return true
+}
+
+// method Quit() makes every vnode leave the ring, handing its keys to its successor.
+// It stops at the first vnode which could not leave and returns its error, see Node.Leave(),
+// so that the host keeps running until a retry makes the vnodes still in the ring leave
+func (h *Host) Quit() error {
+	for i := len(h.Nodes) - 1; i >= 0; i-- {
+		if h.Nodes[i].ON == false { // left already
+			continue
+		}
+		err := h.Nodes[i].Quit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// method On() reports whether a vnode of the host is still in a ring
+func (h *Host) On() bool {
+	for _, o := range h.Nodes {
+		if o.ON {
+			return true
+		}
+	}
+	return false
+}
+
+// method Stop() stops every vnode and the transport of the host
+func (h *Host) Stop() error {
+	var err error
+	for _, o := range h.Nodes {
+		if e := o.Stop(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if e := h.transport.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+// Package config reads the parameters of chord and kademlia nodes from JSON files
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// Duration is a time.Duration written in JSON as a string like "100ms"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var str string
+	err := json.Unmarshal(b, &str)
+	if err != nil {
+		return errors.New("Invalid duration " + string(b) + " ")
+	}
+	res, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = Duration(res)
+	return nil
+}
+
+// function Load() decodes the JSON file at path into v. The fields missing from the file
+// keep their values in v, so v is usually filled with the defaults first
+func Load(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(v)
+	if err != nil {
+		return errors.New("Config " + path + ": " + err.Error() + " ")
+	}
+	return nil
+}
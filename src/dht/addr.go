@@ -0,0 +1,84 @@
+// listen and advertised addresses of a node
+
+package dht
+
+import (
+	"errors"
+	"net"
+	"strconv"
+)
+
+// function LocalIP() returns the IP address of the first non-loopback interface which is up,
+// an IPv4 one if there is any
+func LocalIP() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	var ip6 string
+	for _, elt := range ifaces {
+		if elt.Flags&net.FlagLoopback != 0 || elt.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := elt.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if ok == false || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ip4 := ipnet.IP.To4(); ip4 != nil {
+				return ip4.String(), nil
+			}
+			if ip6 == "" {
+				ip6 = ipnet.IP.String()
+			}
+		}
+	}
+	if ip6 == "" {
+		return "", errors.New("No non-loopback interface with an address, give the advertised address ")
+	}
+	return ip6, nil
+}
+
+// function ParseBind() checks the address a node listens at and the one other nodes reach it at,
+// which its ID is derived from. A bare port listens at every interface, and an empty
+// advertised address is the listen address, or the local IP at its port if it has no IP.
+// IPv6 literals are written in brackets, e.g. "[::1]:2000"
+func ParseBind(listen, advertise string) (string, string, error) {
+	if _, err := strconv.Atoi(listen); err == nil {
+		listen = ":" + listen
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", "", err
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", "", errors.New("Invalid port " + port + " ")
+	}
+
+	if advertise == "" {
+		ip := net.ParseIP(host)
+		if host != "" && (ip == nil || ip.IsUnspecified() == false) {
+			return listen, listen, nil
+		}
+		local, err := LocalIP()
+		if err != nil {
+			return "", "", err
+		}
+		return listen, net.JoinHostPort(local, port), nil
+	}
+	host, port, err = net.SplitHostPort(advertise)
+	if err != nil {
+		return "", "", err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		return "", "", errors.New("Advertised address " + advertise + " has no IP ")
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", "", errors.New("Invalid port " + port + " ")
+	}
+	return listen, advertise, nil
+}
@@ -0,0 +1,88 @@
+// batches of puts, gets and deletes
+
+package dht
+
+import (
+	"context"
+	"sync"
+)
+
+// Pair is a key and its value in a batch of puts
+type Pair struct {
+	Key   string
+	Value []byte
+}
+
+// Result is the outcome of one key of a batch, Value being set by the gets only
+type Result struct {
+	Key   string
+	Value []byte
+	Err   error
+}
+
+// Batcher is a Node which groups the keys of a batch by the node responsible for them
+// and ships each group in one call, which only some protocols offer. The results of a
+// batch are in the order of its keys, and a key which failed does not fail the others
+type Batcher interface {
+	Node
+	MultiPut(ctx context.Context, pairs []Pair) []Result
+	MultiGet(ctx context.Context, keys []string) []Result
+	MultiDelete(ctx context.Context, keys []string) []Result
+}
+
+// batchParallel bounds the keys of a batch a node which is not a Batcher handles at a time
+const batchParallel = 16
+
+// function MultiPut() puts pairs through node, as a batch if it is a Batcher
+// and else one key at a time, a few of them in parallel
+func MultiPut(ctx context.Context, node Node, pairs []Pair) []Result {
+	if b, ok := node.(Batcher); ok {
+		return b.MultiPut(ctx, pairs)
+	}
+	res := make([]Result, len(pairs))
+	each(len(pairs), func(i int) {
+		res[i] = Result{Key: pairs[i].Key, Err: node.Put(ctx, pairs[i].Key, pairs[i].Value)}
+	})
+	return res
+}
+
+// function MultiGet() gets keys through node, see MultiPut()
+func MultiGet(ctx context.Context, node Node, keys []string) []Result {
+	if b, ok := node.(Batcher); ok {
+		return b.MultiGet(ctx, keys)
+	}
+	res := make([]Result, len(keys))
+	each(len(keys), func(i int) {
+		value, err := node.Get(ctx, keys[i])
+		res[i] = Result{keys[i], value, err}
+	})
+	return res
+}
+
+// function MultiDelete() deletes keys through node, see MultiPut()
+func MultiDelete(ctx context.Context, node Node, keys []string) []Result {
+	if b, ok := node.(Batcher); ok {
+		return b.MultiDelete(ctx, keys)
+	}
+	res := make([]Result, len(keys))
+	each(len(keys), func(i int) {
+		res[i] = Result{Key: keys[i], Err: node.Del(ctx, keys[i])}
+	})
+	return res
+}
+
+// function each() runs f(0), ..., f(n-1), batchParallel of them at a time
+func each(n int, f func(i int)) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, batchParallel)
+	for i := 0; i < n; i++ {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,219 @@
+// chord as a dht Node
+
+package dht
+
+import (
+	"chord"
+	"context"
+	"fmt"
+	"log/slog"
+	"message"
+	"net"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ChordNode is a chord host as a Node
+type ChordNode struct {
+	O      *chord.Node // vnode 0 of H
+	H      *chord.Host
+	Listen string
+	log    *slog.Logger
+}
+
+// function NewChordNode() returns a chord host listening at listen and reached at advertise
+// (see ParseBind()) with vnodes virtual nodes, keeping its keys in dataDir if it is not empty
+func NewChordNode(listen, advertise string, vnodes int, dataDir string, cfg chord.Config) (*ChordNode, error) {
+	listen, advertise, err := ParseBind(listen, advertise)
+	if err != nil {
+		return nil, err
+	}
+	_, port, _ := net.SplitHostPort(advertise)
+	o := new(ChordNode)
+	o.Listen = listen
+	o.H = chord.NewHost(advertise, chord.NewRPCTransport(listen), vnodes, cfg)
+	o.O = o.H.Nodes[0]
+	o.log = slog.Default().With(chord.LogNode, advertise)
+	if dataDir != "" {
+		o.H.Setup = func(node *chord.Node, i int) {
+			dir := filepath.Join(dataDir, port)
+			if i > 0 {
+				dir += "#" + strconv.Itoa(i)
+			}
+			openStorage(node, dir, o.log)
+		}
+	}
+	return o, nil
+}
+
+// method SetLogger() replaces the logger of the host and its vnodes
+func (o *ChordNode) SetLogger(l *slog.Logger) {
+	o.log = l.With(chord.LogNode, o.O.Addr)
+	o.H.SetLogger(l)
+}
+
+// function openStorage() keeps the data of the node in dir, so that it survives a restart,
+// every write being synced to disk before it is acknowledged, see chord.FileStorage
+func openStorage(o *chord.Node, dir string, log *slog.Logger) {
+	data, err := chord.OpenFileStorage(filepath.Join(dir, "data"))
+	if err != nil {
+		log.Error("open storage failed", chord.LogOp, "storage", "dir", dir, "err", err)
+		return
+	}
+	dataPre, err := chord.OpenFileStorage(filepath.Join(dir, "datapre"))
+	if err != nil {
+		_ = data.Close()
+		log.Error("open storage failed", chord.LogOp, "storage", "dir", dir, "err", err)
+		return
+	}
+	o.SetStorage(data, dataPre)
+}
+
+func (o *ChordNode) Get(ctx context.Context, k string) ([]byte, error) {
+	return o.O.Get(ctx, k)
+}
+
+func (o *ChordNode) Put(ctx context.Context, k string, v []byte) error {
+	return o.O.Put(ctx, k, v)
+}
+
+func (o *ChordNode) Del(ctx context.Context, k string) error {
+	return o.O.Delete(ctx, k)
+}
+
+func (o *ChordNode) GetWithVersion(ctx context.Context, k string) ([]byte, string, error) {
+	return o.O.GetWithVersion(ctx, k)
+}
+
+func (o *ChordNode) PutIfAbsent(ctx context.Context, k string, v []byte) error {
+	return o.O.PutIfAbsent(ctx, k, v)
+}
+
+func (o *ChordNode) CompareAndSwap(ctx context.Context, k, version string, v []byte) error {
+	return o.O.CompareAndSwap(ctx, k, version, v)
+}
+
+func (o *ChordNode) DelIfVersion(ctx context.Context, k, version string) error {
+	return o.O.DeleteIfVersion(ctx, k, version)
+}
+
+func (o *ChordNode) GetLevel(ctx context.Context, k string, level chord.Consistency) ([]byte, error) {
+	return o.O.GetLevel(ctx, k, level)
+}
+
+func (o *ChordNode) PutLevel(ctx context.Context, k string, v []byte, level chord.Consistency) error {
+	return o.O.PutLevel(ctx, k, v, level)
+}
+
+func (o *ChordNode) DelLevel(ctx context.Context, k string, level chord.Consistency) error {
+	return o.O.DeleteLevel(ctx, k, level)
+}
+
+func (o *ChordNode) PutTTL(ctx context.Context, k string, v []byte, ttl time.Duration) error {
+	return o.O.PutWithTTL(ctx, k, v, ttl)
+}
+
+func (o *ChordNode) PutLevelTTL(ctx context.Context, k string, v []byte, ttl time.Duration, level chord.Consistency) error {
+	return o.O.PutLevelWithTTL(ctx, k, v, ttl, level)
+}
+
+func (o *ChordNode) Expire(ctx context.Context, k string, ttl time.Duration) error {
+	return o.O.Expire(ctx, k, ttl)
+}
+
+func (o *ChordNode) MultiPut(ctx context.Context, pairs []Pair) []Result {
+	kvs := make([]chord.KVPair, len(pairs))
+	for i, p := range pairs {
+		kvs[i] = chord.KVPair{Key: p.Key, Value: p.Value}
+	}
+	return chordResults(o.O.MultiPut(ctx, kvs))
+}
+
+func (o *ChordNode) MultiGet(ctx context.Context, keys []string) []Result {
+	return chordResults(o.O.MultiGet(ctx, keys))
+}
+
+func (o *ChordNode) MultiDelete(ctx context.Context, keys []string) []Result {
+	return chordResults(o.O.MultiDelete(ctx, keys))
+}
+
+// function chordResults() returns the results of a batch of a chord node
+func chordResults(res []chord.BatchResult) []Result {
+	out := make([]Result, len(res))
+	for i, r := range res {
+		out[i] = Result(r)
+	}
+	return out
+}
+
+// method Lookup() returns the node owning k and the nodes an iterative lookup of it went through
+func (o *ChordNode) Lookup(ctx context.Context, k string) (string, []string, error) {
+	owner, path, err := o.O.Lookup(ctx, k)
+	return owner.Addr, path, err
+}
+
+func (o *ChordNode) Run() {
+	err := o.H.Serve()
+	if err != nil {
+		o.log.Error("listen failed", chord.LogOp, "run", "listen", o.Listen, "err", err)
+		return
+	}
+}
+
+func (o *ChordNode) Create() {
+	o.H.Create()
+
+	message.PrintTime()
+	fmt.Println("create: success", o.O.Addr)
+}
+
+func (o *ChordNode) Join(addr string) bool {
+	res := o.H.Join(addr)
+
+	message.PrintTime()
+	if res == true {
+		fmt.Println("join:", o.O.Addr, "join a ring containing", addr)
+	} else {
+		fmt.Println("join: join failure", addr)
+	}
+
+	return res
+}
+
+func (o *ChordNode) Quit() error {
+	if o.H.On() == false {
+		return nil
+	}
+	err := o.H.Quit()
+	if err != nil {
+		return err
+	}
+	err = o.H.Stop()
+	if err != nil {
+		o.log.Error("close failed", chord.LogOp, "quit", "err", err)
+	}
+	return nil
+}
+
+func (o *ChordNode) ForceQuit() {
+	err := o.H.Stop()
+	if err != nil {
+		o.log.Error("close failed", chord.LogOp, "force_quit", "err", err)
+	}
+	fmt.Println("Force quit success")
+}
+
+func (o *ChordNode) Ping(addr string) bool {
+	return o.O.Ping(addr)
+}
+
+func (o *ChordNode) GetAddr() string {
+	return o.O.Addr
+}
+
+func (o *ChordNode) Dump() {
+	for _, node := range o.H.Nodes {
+		node.Dump()
+	}
+}
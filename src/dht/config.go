@@ -0,0 +1,36 @@
+// configuration file of a node
+
+package dht
+
+import (
+	"chord"
+	"config"
+	"kademlia"
+)
+
+// Config is the configuration file of a node, with a section for each protocol, e.g.
+//
+//	{"chord": {"successor_list_len": 8, "stabilize_interval": "1s"}, "kademlia": {"alpha": 5}}
+type Config struct {
+	Chord    chord.Config    `json:"chord"`
+	Kademlia kademlia.Config `json:"kademlia"`
+}
+
+// function DefaultConfig() returns the defaults of both protocols
+func DefaultConfig() Config {
+	return Config{chord.DefaultConfig(), kademlia.DefaultConfig()}
+}
+
+// function LoadConfig() reads the JSON file at path, the defaults standing for missing fields
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	err := config.Load(path, &cfg)
+	if err != nil {
+		return cfg, err
+	}
+	err = cfg.Chord.Validate()
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Kademlia.Validate()
+}
@@ -0,0 +1,47 @@
+// Package dht is the contract shared by the chord and kademlia nodes,
+// so that one test harness and one command line drive either protocol
+package dht
+
+import (
+	"context"
+	"time"
+)
+
+// Node is a node of a distributed hash table. Get, Put and Del give up with ErrTimeout
+// once the deadline of ctx passes, and report a missing key as ErrNotFound.
+// Quit leaves the ring gracefully, and fails if the node could not hand its keys over,
+// in which case it keeps running, to quit again or ForceQuit
+type Node interface {
+	Get(ctx context.Context, k string) ([]byte, error)
+	Put(ctx context.Context, k string, v []byte) error
+	Del(ctx context.Context, k string) error
+	Run()
+	Create()
+	Join(addr string) bool
+	Quit() error
+	ForceQuit()
+	Ping(addr string) bool
+
+	GetAddr() string
+	State() State
+	Dump()
+}
+
+// CASNode is a Node with conditional writes, which only some protocols offer.
+// A write whose condition does not hold returns ErrConditionFailed
+type CASNode interface {
+	Node
+	GetWithVersion(ctx context.Context, k string) ([]byte, string, error)
+	PutIfAbsent(ctx context.Context, k string, v []byte) error
+	CompareAndSwap(ctx context.Context, k string, version string, v []byte) error
+	DelIfVersion(ctx context.Context, k string, version string) error
+}
+
+// Expirer is a Node whose keys can be given a time to live, which only some protocols offer.
+// A key is read as missing once it expired
+type Expirer interface {
+	Node
+	PutTTL(ctx context.Context, k string, v []byte, ttl time.Duration) error
+	// Expire makes an existing key expire after ttl, ErrNotFound if it does not exist
+	Expire(ctx context.Context, k string, ttl time.Duration) error
+}
@@ -0,0 +1,29 @@
+// errors of the operations of a Node, the same for every protocol
+
+package dht
+
+import (
+	"chord"
+	"kademlia"
+)
+
+var (
+	ErrNotFound           = chord.ErrNotFound
+	ErrNoSuccessor        = chord.ErrNoSuccessor
+	ErrTimeout            = chord.ErrTimeout
+	ErrLookupHopsExceeded = chord.ErrLookupHopsExceeded
+	ErrConditionFailed    = chord.ErrConditionFailed
+	ErrInvalidTTL         = chord.ErrInvalidTTL
+	ErrNotOwner           = kademlia.ErrNotOwner // a kademlia key is only written by the node which published it
+)
+
+// function kademliaError() returns the error of this package for an error of kademlia
+func kademliaError(err error) error {
+	switch err {
+	case kademlia.ErrNotFound:
+		return ErrNotFound
+	case kademlia.ErrTimeout:
+		return ErrTimeout
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+// kademlia as a dht Node
+
+package dht
+
+import (
+	"context"
+	"fmt"
+	"kademlia"
+	"log/slog"
+	"message"
+)
+
+// KademliaNode is a kademlia node as a Node
+type KademliaNode struct {
+	O      *kademlia.Node
+	Listen string
+	log    *slog.Logger
+}
+
+// function NewKademliaNode() returns a kademlia node listening at listen and reached at advertise,
+// see ParseBind()
+func NewKademliaNode(listen, advertise string, cfg kademlia.Config) (*KademliaNode, error) {
+	listen, advertise, err := ParseBind(listen, advertise)
+	if err != nil {
+		return nil, err
+	}
+	o := new(KademliaNode)
+	o.Listen = listen
+	o.O = kademlia.NewNode(advertise, kademlia.NewRPCTransport(listen), cfg)
+	o.log = slog.Default().With(kademlia.LogNode, advertise)
+	return o, nil
+}
+
+// method SetLogger() replaces the logger of the node
+func (o *KademliaNode) SetLogger(l *slog.Logger) {
+	o.log = l.With(kademlia.LogNode, o.O.O.IP)
+	o.O.SetLogger(l)
+}
+
+func (o *KademliaNode) Get(ctx context.Context, k string) ([]byte, error) {
+	res, err := o.O.O.GetValue(ctx, k)
+	return res, kademliaError(err)
+}
+
+func (o *KademliaNode) Put(ctx context.Context, k string, v []byte) error {
+	return kademliaError(o.O.O.Publish(ctx, k, v))
+}
+
+func (o *KademliaNode) Del(ctx context.Context, k string) error {
+	return kademliaError(o.O.O.Delete(ctx, k))
+}
+
+func (o *KademliaNode) Run() {
+	err := o.O.Serve()
+	if err != nil {
+		o.log.Error("listen failed", kademlia.LogOp, "run", "listen", o.Listen, "err", err)
+		return
+	}
+}
+
+func (o *KademliaNode) Create() {
+	message.PrintTime()
+	fmt.Println("create: success", o.O.O.IP)
+}
+
+func (o *KademliaNode) Join(addr string) bool {
+	res := o.O.O.Join(addr)
+
+	message.PrintTime()
+	if res == true {
+		fmt.Println("join:", o.O.O.IP, "join a network containing", addr)
+	} else {
+		fmt.Println("join: join failure", addr)
+	}
+
+	return res
+}
+
+// method Quit() stops the node, its keys stay at the nodes they were published to
+func (o *KademliaNode) Quit() error {
+	if o.O.O.ON == false {
+		return nil
+	}
+	err := o.O.Stop()
+	if err != nil {
+		o.log.Error("close failed", kademlia.LogOp, "quit", "err", err)
+	}
+	return nil
+}
+
+func (o *KademliaNode) ForceQuit() {
+	err := o.O.Stop()
+	if err != nil {
+		o.log.Error("close failed", kademlia.LogOp, "force_quit", "err", err)
+	}
+	fmt.Println("Force quit success")
+}
+
+func (o *KademliaNode) Ping(addr string) bool {
+	return o.O.O.Ping(addr)
+}
+
+func (o *KademliaNode) GetAddr() string {
+	return o.O.O.IP
+}
+
+func (o *KademliaNode) Dump() {
+	o.O.O.Dump()
+}
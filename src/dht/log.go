@@ -0,0 +1,29 @@
+// loggers of the nodes
+
+package dht
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+)
+
+// function NewLogger() returns a logger writing entries of level and above to w as
+// format "text" or "json", level being "debug", "info", "warn" or "error".
+// Every entry of a node carries its address as "node" and its operation as "op",
+// so that e.g. the stabilization of one node is filtered out by both
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, errors.New("Unknown log format " + format + " ")
+}
@@ -0,0 +1,41 @@
+// state of a node, whatever its protocol
+
+package dht
+
+import (
+	"chord"
+	"kademlia"
+)
+
+// State is a snapshot of a node. Vnodes is set for a chord node, Kademlia for a kademlia node
+type State struct {
+	Protocol string              `json:"protocol"`
+	Addr     string              `json:"addr"`
+	Vnodes   []chord.NodeState   `json:"vnodes,omitempty"`
+	Kademlia *kademlia.NodeState `json:"kademlia,omitempty"`
+}
+
+// method Addrs() returns the addresses the node is known by in the ring, one per vnode
+func (s State) Addrs() []string {
+	if s.Kademlia != nil {
+		return []string{s.Kademlia.Addr}
+	}
+	var res []string
+	for _, v := range s.Vnodes {
+		res = append(res, v.Addr)
+	}
+	return res
+}
+
+func (o *ChordNode) State() State {
+	res := State{Protocol: "chord", Addr: o.O.Addr}
+	for _, node := range o.H.Nodes {
+		res.Vnodes = append(res.Vnodes, node.State())
+	}
+	return res
+}
+
+func (o *KademliaNode) State() State {
+	s := o.O.State()
+	return State{Protocol: "kademlia", Addr: s.Addr, Kademlia: &s}
+}
@@ -0,0 +1,546 @@
+// Package httpapi serves a node over HTTP with JSON bodies, so that services which
+// do not link the Go code can use the DHT:
+//
+//	GET    /kv/{key}       the value of key, {"key": ..., "value": ...}, or the value itself
+//	                       if the request accepts application/octet-stream
+//	PUT    /kv/{key}       sets key to the request body, or to "value" of a JSON body
+//	DELETE /kv/{key}       deletes key
+//	GET    /obj/{key}      the object stored under key, streamed, see objstore
+//	PUT    /obj/{key}      stores the request body as an object in chunks, and returns its manifest
+//	DELETE /obj/{key}      deletes the object stored under key
+//	GET    /admin/state    the ring state of the node, see dht.State
+//	GET    /admin/stats    the metrics of the node
+//	POST   /admin/create   creates a ring
+//	POST   /admin/join     joins the ring of {"addr": ...}
+//	POST   /admin/leave    leaves the ring, handing over the keys of the node
+//	GET    /metrics        the metrics of the process in the Prometheus text format
+//
+// A value which is not UTF-8 is given in JSON in base64 with "encoding": "base64", which a PUT
+// of a JSON body takes as well. Objects are not bounded in size, and their operations give up
+// only once the request is canceled.
+// A chord node also takes ?level=one|quorum|all on /kv, and ?ttl=30s on a PUT. Errors are {"error": ...}
+// with 404 for a missing key, 504 for a timeout and 409 for a ring operation which
+// does not fit the node, e.g. a join once it is in a ring.
+//
+// Create, join and leave change the ring, so they are served only to a request bearing the
+// AdminToken of the server as "Authorization: Bearer {token}", or without a token to requests
+// from the loopback interface only. Objects are streamed without the deadlines of NewHTTPServer.
+package httpapi
+
+import (
+	"chord"
+	"context"
+	"crypto/subtle"
+	"dht"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"metrics"
+	"mime"
+	"net"
+	"net/http"
+	"objstore"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxValueSize bounds the body of a PUT
+const MaxValueSize = 1 << 20
+
+var (
+	ErrInRing    = errors.New("The node is already in a ring ")
+	ErrNotInRing = errors.New("The node is not in a ring ")
+	ErrLeft      = errors.New("The node has left its ring ")
+	ErrJoin      = errors.New("Join failure ")
+	ErrForbidden = errors.New("Admin operations need the admin token, or a request from the loopback interface ")
+)
+
+// deadlines of a server from NewHTTPServer()
+const (
+	ReadHeaderTimeout = 10 * time.Second
+	ReadTimeout       = 30 * time.Second
+	WriteTimeout      = 30 * time.Second
+	IdleTimeout       = 2 * time.Minute
+)
+
+// Server serves a node. It keeps whether the node is in a ring, as a dht.Node does not tell
+type Server struct {
+	AdminToken string // the bearer token of create, join and leave, loopback only if empty
+
+	node    dht.Node
+	reg     *metrics.Registry
+	timeout time.Duration
+	mux     *http.ServeMux
+	objects *objstore.Store
+
+	lock   sync.Mutex // serializes create, join and leave
+	inRing bool
+	left   bool
+	done   chan struct{}
+}
+
+// function New() returns a server of node, outside of any ring yet, whose operations give up
+// after timeout and whose stats are read from reg
+func New(node dht.Node, reg *metrics.Registry, timeout time.Duration) *Server {
+	s := &Server{node: node, reg: reg, timeout: timeout, mux: http.NewServeMux(), objects: objstore.New(node),
+		done: make(chan struct{})}
+	s.mux.HandleFunc("/kv/", s.kv)
+	s.mux.HandleFunc("/obj/", s.obj)
+	s.mux.HandleFunc("/admin/state", method(http.MethodGet, s.state))
+	s.mux.HandleFunc("/admin/stats", method(http.MethodGet, s.stats))
+	s.mux.HandleFunc("/admin/create", method(http.MethodPost, s.admin(s.create)))
+	s.mux.HandleFunc("/admin/join", method(http.MethodPost, s.admin(s.join)))
+	s.mux.HandleFunc("/admin/leave", method(http.MethodPost, s.admin(s.leave)))
+	s.mux.HandleFunc("/metrics", method(http.MethodGet, reg.Handler().ServeHTTP))
+	return s
+}
+
+// function NewHTTPServer() returns an http.Server of s at addr with the deadlines of the package
+func NewHTTPServer(addr string, s *Server) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: ReadHeaderTimeout,
+		ReadTimeout:       ReadTimeout,
+		WriteTimeout:      WriteTimeout,
+		IdleTimeout:       IdleTimeout,
+	}
+}
+
+// method admin() returns a handler which serves only administrators with h, see AdminToken
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.AdminToken != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if ok == false || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, ErrForbidden)
+				return
+			}
+		} else if loopback(r.RemoteAddr) == false {
+			writeError(w, http.StatusForbidden, ErrForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// function loopback() returns whether the remote address of a request is on the loopback interface
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// function method() returns a handler which serves only requests of method m with h
+func method(m string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m {
+			w.Header().Set("Allow", m)
+			writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed "))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// method kv() serves /kv/{key}
+func (s *Server) kv(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/kv/")
+	if key == "" {
+		writeError(w, http.StatusNotFound, errors.New("Expected /kv/{key} "))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.get(w, r, key)
+	case http.MethodPut:
+		s.put(w, r, key)
+	case http.MethodDelete:
+		s.del(w, r, key)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed "))
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// method Done() returns a channel closed once the node left its ring
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// method Create() makes the node create a ring
+func (s *Server) Create() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.outOfRing(); err != nil {
+		return err
+	}
+	s.node.Create()
+	s.inRing = true
+	return nil
+}
+
+// method Join() makes the node join the ring containing addr
+func (s *Server) Join(addr string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.outOfRing(); err != nil {
+		return err
+	}
+	if s.node.Join(addr) == false {
+		return ErrJoin
+	}
+	s.inRing = true
+	return nil
+}
+
+// method Leave() makes the node leave its ring gracefully, after which it stops serving.
+// If the node could not hand its keys over, it stays in its ring
+func (s *Server) Leave() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.left {
+		return ErrLeft
+	}
+	if s.inRing == false {
+		return ErrNotInRing
+	}
+	err := s.node.Quit()
+	if err != nil {
+		return err
+	}
+	s.inRing, s.left = false, true
+	close(s.done)
+	return nil
+}
+
+// method outOfRing() returns why the node cannot create or join a ring, nil if it can
+func (s *Server) outOfRing() error {
+	if s.left {
+		return ErrLeft
+	}
+	if s.inRing {
+		return ErrInRing
+	}
+	return nil
+}
+
+// method context() returns the context of an operation of r, which gives up after the timeout
+func (s *Server) context(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), s.timeout)
+}
+
+// method level() returns the consistency level of ?level and the node as a chord node,
+// which alone has levels. c is nil if there is no ?level
+func (s *Server) level(r *http.Request) (c *dht.ChordNode, l chord.Consistency, err error) {
+	str := r.URL.Query().Get("level")
+	if str == "" {
+		return nil, l, nil
+	}
+	l, err = chord.ParseConsistency(str)
+	if err != nil {
+		return nil, l, err
+	}
+	c, ok := s.node.(*dht.ChordNode)
+	if ok == false {
+		return nil, l, errors.New("Only chord nodes have consistency levels ")
+	}
+	return c, l, nil
+}
+
+type kvBody struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"` // "base64" if Value is, for values which are not UTF-8
+}
+
+// function newKVBody() returns the JSON body of a pair
+func newKVBody(key string, value []byte) kvBody {
+	if utf8.Valid(value) {
+		return kvBody{Key: key, Value: string(value)}
+	}
+	return kvBody{key, base64.StdEncoding.EncodeToString(value), "base64"}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, key string) {
+	c, l, err := s.level(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := s.context(r)
+	defer cancel()
+	var value []byte
+	if c != nil {
+		value, err = c.GetLevel(ctx, key, l)
+	} else {
+		value, err = s.node.Get(ctx, key)
+	}
+	if err != nil {
+		writeError(w, status(err), err)
+		return
+	}
+	if accepts(r, "application/octet-stream") {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(value)
+		return
+	}
+	writeJSON(w, http.StatusOK, newKVBody(key, value))
+}
+
+// function accepts() returns whether r accepts mediaType by name, regardless of its weight
+func accepts(r *http.Request, mediaType string) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, t := range strings.Split(accept, ",") {
+			parsed, _, err := mime.ParseMediaType(strings.TrimSpace(t))
+			if err == nil && parsed == mediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, key string) {
+	c, l, err := s.level(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var ttl time.Duration
+	if str := r.URL.Query().Get("ttl"); str != "" {
+		ttl, err = time.ParseDuration(str)
+		if err == nil && ttl <= 0 {
+			err = dht.ErrInvalidTTL
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	e, ok := s.node.(dht.Expirer)
+	if ttl > 0 && ok == false {
+		writeError(w, http.StatusBadRequest, errors.New("Only chord nodes have TTLs "))
+		return
+	}
+	value, err := readValue(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := s.context(r)
+	defer cancel()
+	switch {
+	case c != nil && ttl > 0:
+		err = c.PutLevelTTL(ctx, key, value, ttl, l)
+	case c != nil:
+		err = c.PutLevel(ctx, key, value, l)
+	case ttl > 0:
+		err = e.PutTTL(ctx, key, value, ttl)
+	default:
+		err = s.node.Put(ctx, key, value)
+	}
+	if err != nil {
+		writeError(w, status(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) del(w http.ResponseWriter, r *http.Request, key string) {
+	c, l, err := s.level(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := s.context(r)
+	defer cancel()
+	if c != nil {
+		err = c.DelLevel(ctx, key, l)
+	} else {
+		err = s.node.Del(ctx, key)
+	}
+	if err != nil {
+		writeError(w, status(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// function readValue() reads the value of a PUT, the body itself unless it is JSON
+func readValue(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxValueSize))
+	if err != nil {
+		return nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return body, nil
+	}
+	var kv kvBody
+	err = json.Unmarshal(body, &kv)
+	if err != nil {
+		return nil, err
+	}
+	switch kv.Encoding {
+	case "":
+		return []byte(kv.Value), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(kv.Value)
+	}
+	return nil, errors.New("Unknown encoding " + kv.Encoding + " ")
+}
+
+// method obj() serves /obj/{key}
+func (s *Server) obj(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/obj/")
+	if key == "" {
+		writeError(w, http.StatusNotFound, errors.New("Expected /obj/{key} "))
+		return
+	}
+	// objects are not bounded in size, so their transfers are not bounded in time
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+	switch r.Method {
+	case http.MethodGet:
+		s.getObject(w, r, key)
+	case http.MethodPut:
+		m, err := s.objects.Put(r.Context(), key, r.Body)
+		if err != nil {
+			writeError(w, status(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	case http.MethodDelete:
+		err := s.objects.Delete(r.Context(), key)
+		if err != nil {
+			writeError(w, status(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed "))
+	}
+}
+
+// method getObject() streams an object. The status is sent before its chunks are read,
+// so a chunk which fails cuts the response short instead
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	ctx := r.Context()
+	m, err := s.objects.Stat(ctx, key)
+	if err != nil {
+		writeError(w, status(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(m.Size, 10))
+	w.Header().Set("ETag", `"`+m.Hash+`"`)
+	err = s.objects.Read(ctx, m, w)
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *Server) state(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.node.State())
+}
+
+// Stats are the metrics of a node, by name{labels} as in the Prometheus text format
+type Stats struct {
+	Protocol string             `json:"protocol"`
+	Addr     string             `json:"addr"`
+	InRing   bool               `json:"in_ring"`
+	Metrics  map[string]float64 `json:"metrics"`
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	state := s.node.State()
+	s.lock.Lock()
+	res := Stats{Protocol: state.Protocol, Addr: state.Addr, InRing: s.inRing, Metrics: make(map[string]float64)}
+	s.lock.Unlock()
+	for _, addr := range state.Addrs() {
+		for k, v := range s.reg.Snapshot(chord.LogNode, addr) {
+			res.Metrics[k] = v
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	err := s.Create()
+	if err != nil {
+		writeError(w, status(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) join(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Addr string `json:"addr"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxValueSize)).Decode(&req)
+	if err != nil || req.Addr == "" {
+		writeError(w, http.StatusBadRequest, errors.New("Expected {\"addr\": ...} "))
+		return
+	}
+	err = s.Join(req.Addr)
+	if err != nil {
+		writeError(w, status(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) leave(w http.ResponseWriter, r *http.Request) {
+	err := s.Leave()
+	if err != nil {
+		writeError(w, status(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// function status() returns the HTTP status of the error of an operation
+func status(err error) int {
+	switch {
+	case errors.Is(err, dht.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, dht.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, dht.ErrConditionFailed), errors.Is(err, ErrInRing),
+		errors.Is(err, ErrNotInRing), errors.Is(err, ErrLeft):
+		return http.StatusConflict
+	case errors.Is(err, dht.ErrNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, objstore.ErrCorrupt):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadGateway // the ring failed the node
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,99 @@
+package kademlia
+
+import (
+	"config"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Config holds the parameters of a node. Every node of a network must use the same B,
+// and a zero field means the default
+type Config struct {
+	BucketSize int `json:"bucket_size"` // k, the contacts of a k-bucket and the copies of a pair
+	Alpha      int `json:"alpha"`       // contacts a lookup starts with
+	B          int `json:"b"`           // bits of an ID, at most 160 (SHA-1)
+
+	Expire    config.Duration `json:"expire"`    // lifetime of a stored pair
+	Republish config.Duration `json:"republish"` // period of publishing own pairs again
+	Refresh   config.Duration `json:"refresh"`   // a k-bucket idle this long is refreshed
+	Replicate config.Duration `json:"replicate"` // a stored pair is replicated this long after it is stored
+	Check     config.Duration `json:"check"`     // period of the expiry, replication and refresh checks
+}
+
+// DefaultConfig returns the parameters a node uses unless told otherwise
+func DefaultConfig() Config {
+	return Config{
+		BucketSize: 20,
+		Alpha:      3,
+		B:          160,
+		Expire:     config.Duration(time.Minute),      // 24*time.Hour + 10*time.Second
+		Republish:  config.Duration(time.Minute),      // 24 * time.Hour
+		Refresh:    config.Duration(30 * time.Second), // time.Hour
+		Replicate:  config.Duration(30 * time.Second), // time.Hour
+		Check:      config.Duration(10 * time.Second), // time.Minute
+	}
+}
+
+// WithDefaults returns the config with its zero fields set to the defaults
+func (c Config) WithDefaults() Config {
+	def := DefaultConfig()
+	if c.BucketSize == 0 {
+		c.BucketSize = def.BucketSize
+	}
+	if c.Alpha == 0 {
+		c.Alpha = def.Alpha
+	}
+	if c.B == 0 {
+		c.B = def.B
+	}
+	if c.Expire == 0 {
+		c.Expire = def.Expire
+	}
+	if c.Republish == 0 {
+		c.Republish = def.Republish
+	}
+	if c.Refresh == 0 {
+		c.Refresh = def.Refresh
+	}
+	if c.Replicate == 0 {
+		c.Replicate = def.Replicate
+	}
+	if c.Check == 0 {
+		c.Check = def.Check
+	}
+	return c
+}
+
+// Validate checks that a node can run with the config
+func (c Config) Validate() error {
+	switch {
+	case c.BucketSize < 1:
+		return fmt.Errorf("Config: bucket_size = %d, must be positive ", c.BucketSize)
+	case c.Alpha < 1 || c.Alpha > c.BucketSize:
+		return fmt.Errorf("Config: alpha = %d, must be in [1, bucket_size] ", c.Alpha)
+	case c.B < 1 || c.B > 160:
+		return fmt.Errorf("Config: b = %d, must be in [1, 160] ", c.B)
+	case c.Expire <= 0 || c.Republish <= 0 || c.Refresh <= 0 || c.Replicate <= 0 || c.Check <= 0:
+		return errors.New("Config: intervals must be positive ")
+	}
+	return nil
+}
+
+func (c Config) expire() time.Duration    { return time.Duration(c.Expire) }
+func (c Config) republish() time.Duration { return time.Duration(c.Republish) }
+func (c Config) refresh() time.Duration   { return time.Duration(c.Refresh) }
+func (c Config) replicate() time.Duration { return time.Duration(c.Replicate) }
+func (c Config) check() time.Duration     { return time.Duration(c.Check) }
+
+// tombstone returns the lifetime of a tombstone: it outlives the copies of older values,
+// and the next Republish of their publishers, which stop republishing when they find it
+func (c Config) tombstone() time.Duration {
+	return c.expire() + 2*c.republish()
+}
+
+// idSpace returns 2^B, the number of IDs
+func (c Config) idSpace() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(c.B))
+}
@@ -0,0 +1,25 @@
+package kademlia
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrNotFound  = errors.New("Key not found ")
+	ErrTimeout   = errors.New("Deadline exceeded ")
+	ErrNotStored = errors.New("No node stored the Key ")
+	ErrNotOwner  = errors.New("Key published by another node ")
+)
+
+// checkContext returns ErrTimeout once the deadline of ctx has passed on the clock of the node,
+// which is virtual on a simulated network, and ctx.Err() once ctx is canceled
+func (o *node) checkContext(ctx context.Context) error {
+	if d, ok := ctx.Deadline(); ok && o.clock.Now().Before(d) == false {
+		return ErrTimeout
+	}
+	if ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
+	return nil
+}
@@ -9,21 +9,18 @@ import (
 type kBucket struct {
 	// 0 for head, most-recently seen at the tail
 	size         int
-	arr          [bucketSize]Contact
+	arr          []Contact // of length k
 	mutex        sync.Mutex
 	latestUpdate time.Time
 }
 
-func (o *kBucket) update(t Contact) {
+func (o *kBucket) update(t Contact, ping func(addr string) bool) {
 	t.Id = new(big.Int).Set(t.Id)
 	o.mutex.Lock()
-	defer func() {
-		o.mutex.Unlock()
-		o.latestUpdate = time.Now()
-	}()
+	defer o.mutex.Unlock()
 
 	for i := 0; i < o.size; i++ {
-		if Ping(o.arr[i].Ip) == false {
+		if ping(o.arr[i].Ip) == false {
 			for j := i; j < o.size-1; j++ {
 				o.arr[j] = o.arr[j+1]
 			}
@@ -40,12 +37,12 @@ func (o *kBucket) update(t Contact) {
 			return
 		}
 	}
-	if o.size < bucketSize {
+	if o.size < len(o.arr) {
 		o.arr[o.size] = t
 		o.size++
 		return
 	}
-	success := Ping(o.arr[0].Ip)
+	success := ping(o.arr[0].Ip)
 	if success == true {
 		tmp := o.arr[0]
 		for i := 0; i < o.size-1; i++ {
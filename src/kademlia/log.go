@@ -0,0 +1,21 @@
+package kademlia
+
+import (
+	"log/slog"
+)
+
+// Keys of the attributes every log entry of a node carries
+const (
+	LogNode = "node"
+	LogOp   = "op"
+)
+
+// SetLogger replaces the logger of the node, slog.Default() when the node was made
+func (o *Node) SetLogger(l *slog.Logger) {
+	o.O.log = l.With(LogNode, o.O.IP)
+}
+
+// logger returns the logger of the node for the operation op
+func (o *node) logger(op string) *slog.Logger {
+	return o.log.With(LogOp, op)
+}
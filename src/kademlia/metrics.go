@@ -0,0 +1,101 @@
+package kademlia
+
+import (
+	"errors"
+	"metrics"
+	"time"
+)
+
+var errPing = errors.New("Ping failure ")
+
+// nodeMetrics are the metrics of a node, labeled by its address
+type nodeMetrics struct {
+	reg  *metrics.Registry
+	node string
+
+	queried *metrics.Histogram
+}
+
+// SetMetrics registers the metrics of the node in reg, metrics.Default when the node was made
+func (o *Node) SetMetrics(reg *metrics.Registry) {
+	n := &o.O
+	m := &nodeMetrics{reg: reg, node: n.IP}
+	m.queried = reg.Histogram("kademlia_lookup_queried_nodes", "Nodes queried by the lookups of the node.",
+		metrics.HopBuckets, LogNode, n.IP)
+	reg.GaugeFunc("kademlia_keys", "Pairs stored at the node, tombstones and cached copies included.",
+		func() float64 { return float64(n.Data.len()) }, LogNode, n.IP, "map", "data")
+	reg.GaugeFunc("kademlia_keys", "Pairs stored at the node, tombstones and cached copies included.",
+		func() float64 { return float64(n.publishMap.len()) }, LogNode, n.IP, "map", "published")
+	reg.GaugeFunc("kademlia_contacts", "Contacts in the k-buckets of the node.",
+		func() float64 { contacts, _, _ := n.bucketFill(); return float64(contacts) }, LogNode, n.IP)
+	reg.GaugeFunc("kademlia_kbuckets_nonempty", "K-buckets of the node holding a contact.",
+		func() float64 { _, nonempty, _ := n.bucketFill(); return float64(nonempty) }, LogNode, n.IP)
+	reg.GaugeFunc("kademlia_kbuckets_full", "K-buckets of the node holding k contacts.",
+		func() float64 { _, _, full := n.bucketFill(); return float64(full) }, LogNode, n.IP)
+	n.metrics = m
+}
+
+// rpc counts a call of method by the node
+func (m *nodeMetrics) rpc(method string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.reg.Counter("kademlia_rpc_calls_total", "Calls made by the node, by method and result.",
+		LogNode, m.node, "method", method, "result", result).Inc()
+}
+
+// op records the latency and the result of an operation started at start,
+// a missing key being no error
+func (m *nodeMetrics) op(op string, start, end time.Time, err error) {
+	m.reg.Histogram("kademlia_op_duration_seconds", "Latency of the operations of the node.",
+		metrics.LatencyBuckets, LogNode, m.node, LogOp, op).Observe(end.Sub(start).Seconds())
+	if err != nil && err != ErrNotFound {
+		m.reg.Counter("kademlia_op_errors_total", "Failed operations of the node.",
+			LogNode, m.node, LogOp, op).Inc()
+	}
+}
+
+// observe records an operation of the node started at start
+func (o *node) observe(op string, start time.Time, err error) {
+	o.metrics.op(op, start, o.clock.Now(), err)
+}
+
+// bucketFill returns the contacts of the node, and its k-buckets holding some and k of them
+func (o *node) bucketFill() (contacts, nonempty, full int) {
+	for i := range o.kBuckets {
+		b := &o.kBuckets[i]
+		b.mutex.Lock()
+		contacts += b.size
+		if b.size > 0 {
+			nonempty++
+		}
+		if b.size == len(b.arr) {
+			full++
+		}
+		b.mutex.Unlock()
+	}
+	return contacts, nonempty, full
+}
+
+// meteredTransport counts the calls of a node
+type meteredTransport struct {
+	Transport
+	o *node
+}
+
+func (t meteredTransport) Call(addr, method string, args, reply interface{}) error {
+	err := t.Transport.Call(addr, method, args, reply)
+	t.o.metrics.rpc(method, err)
+	return err
+}
+
+func (t meteredTransport) Ping(addr string) bool {
+	ok := t.Transport.Ping(addr)
+	if ok {
+		t.o.metrics.rpc("Ping", nil)
+	} else {
+		t.o.metrics.rpc("Ping", errPing)
+	}
+	return ok
+}
@@ -1,41 +1,116 @@
 package kademlia
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
+	"log/slog"
 	"math/big"
-	"net"
-	"net/rpc"
+	"metrics"
 	"sort"
 	"time"
+	"trace"
 )
 
 type node struct {
-	IP string
-	ID *big.Int
+	IP  string
+	ID  *big.Int
+	key ed25519.PrivateKey // signs the pairs the node publishes
 
-	kBuckets   [B]kBucket
+	kBuckets   []kBucket
 	Data       KVMap
 	publishMap KVMap
 
 	ON bool
+
+	cfg       Config
+	ids       *big.Int // 2^B
+	transport Transport
+	clock     Clock
+	log       *slog.Logger
+	metrics   *nodeMetrics
 }
 
 type Node struct {
-	O      node
-	Listen net.Listener
+	O node
 }
 
-func (o *node) Init(port string) {
-	o.IP = GetLocalAddress() + ":" + port
-	o.ID = hashString(o.IP)
+// NewNode returns a node at addr which talks to other nodes through transport,
+// with the defaults for the zero fields of cfg
+func NewNode(addr string, transport Transport, cfg Config) *Node {
+	cfg = cfg.WithDefaults()
+	err := cfg.Validate()
+	if err != nil {
+		slog.Default().With(LogNode, addr).Error("invalid config, using the default config", LogOp, "config", "err", err)
+		cfg = DefaultConfig()
+	}
+	res := new(Node)
+	o := &res.O
+	o.cfg = cfg
+	o.ids = cfg.idSpace()
+	o.IP = addr
+	o.ID = o.hash(o.IP)
+	res.SetLogger(slog.Default())
+	o.kBuckets = make([]kBucket, cfg.B)
+	for i := range o.kBuckets {
+		o.kBuckets[i].arr = make([]Contact, cfg.BucketSize)
+	}
+	_, o.key, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		o.logger("config").Error("generate key failed", "err", err)
+	}
 	o.publishMap.Map = make(map[string]ValueTimePair)
 	o.Data.Map = make(map[string]ValueTimePair)
+	o.transport = meteredTransport{transport, o}
+	o.clock = realClock{}
+	res.SetMetrics(metrics.Default)
+	return res
+}
+
+// Config returns the parameters of the node
+func (o *Node) Config() Config {
+	return o.O.cfg
+}
+
+// hash returns the ID of a key or an address
+func (o *node) hash(str string) *big.Int {
+	return new(big.Int).Mod(hashString(str), o.ids)
+}
+
+// SetClock replaces the wall clock, e.g. by the virtual clock of a simulated network
+func (o *Node) SetClock(clock Clock) {
+	o.O.clock = clock
 }
 
-func (o *node) Join(addr string) {
-	hash := hashString(addr)
+// Serve starts answering calls from other nodes and the background maintenance
+func (o *Node) Serve() error {
+	err := o.O.transport.Serve(o)
+	if err != nil {
+		return err
+	}
+	o.O.ON = true
+	o.O.clock.Go(o.O.ExpireReplicate)
+	o.O.clock.Go(o.O.Republish)
+	o.O.clock.Go(o.O.Refresh)
+	return nil
+}
+
+// Stop stops answering calls from other nodes
+func (o *Node) Stop() error {
+	o.O.ON = false
+	o.O.metrics.reg.Remove(LogNode, o.O.IP)
+	return o.O.transport.Close()
+}
+
+func (o *node) Join(addr string) bool {
+	if o.Ping(addr) == false {
+		return false
+	}
+	hash := o.hash(addr)
 	o.updateBucket(Contact{hash, addr})
-	o.iterativeFindNode(hash)
+	o.iterativeFindNode(context.Background(), hash)
+	return true
 }
 
 func (o *node) updateBucket(t Contact) {
@@ -43,61 +118,27 @@ func (o *node) updateBucket(t Contact) {
 		return
 	}
 	k := distance(o.ID, t.Id).BitLen() - 1
-	o.kBuckets[k].update(t)
+	o.kBuckets[k].update(t, o.transport.Ping)
+	o.kBuckets[k].latestUpdate = o.clock.Now()
 }
 
-func (o *node) getValue(key string) (string, bool) {
+func (o *node) getValue(key string) (ValueTimePair, bool) {
 	o.Data.lock.Lock()
 	defer o.Data.lock.Unlock()
 
 	val, ok := o.Data.Map[key]
-	return val.val, ok
+	return val, ok
 }
 
 func (o *node) Ping(addr string) bool {
-	var success bool
-	for i := 0; i < 3; i++ {
-		chOK := make(chan bool)
-		go func() {
-			client, err := rpc.Dial("tcp", addr)
-			if err == nil {
-				err = client.Close()
-				chOK <- true
-			} else {
-				chOK <- false
-			}
-		}()
-		select {
-		case ok := <-chOK:
-			if ok {
-				success = true
-			} else {
-				continue
-			}
-		case <-time.After(time.Second / 2):
-			break
-		}
-		if success == true {
-			break
-		}
-	}
-	if success == false {
-		return false
-	}
-	client, err := Dial(addr)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return false
-	}
 	var res PingReturn
-	err = client.Call("Node.RPCPing", Contact{new(big.Int).Set(o.ID), o.IP}, &res)
-	_ = client.Close()
+	err := o.transport.Call(addr, "Node.RPCPing", Contact{new(big.Int).Set(o.ID), o.IP}, &res)
 	if err != nil {
-		fmt.Println("Error:", err)
+		o.logger("ping").Debug("ping failed", "peer", addr, "err", err)
 		return false
 	}
 	if res.Success == true {
-		go o.updateBucket(res.Header)
+		o.clock.Go(func() { o.updateBucket(res.Header) })
 	}
 	return res.Success
 }
@@ -106,8 +147,8 @@ func (o *node) getAlphaNodes(id *big.Int) []Contact {
 	var res []Contact
 	p := distance(o.ID, id).BitLen() - 1
 	o.kBuckets[p].mutex.Lock()
-	if o.kBuckets[p].size >= ALPHA {
-		for i := 0; i < ALPHA; i++ {
+	if o.kBuckets[p].size >= o.cfg.Alpha {
+		for i := 0; i < o.cfg.Alpha; i++ {
 			res = append(res, o.kBuckets[p].arr[i])
 		}
 		o.kBuckets[p].mutex.Unlock()
@@ -115,7 +156,7 @@ func (o *node) getAlphaNodes(id *big.Int) []Contact {
 	}
 	o.kBuckets[p].mutex.Unlock()
 	var arr []Contact
-	for i := 0; i < B; i++ {
+	for i := 0; i < len(o.kBuckets); i++ {
 		o.kBuckets[i].mutex.Lock()
 		for j := 0; j < o.kBuckets[i].size; j++ {
 			arr = append(arr, o.kBuckets[i].arr[j])
@@ -126,8 +167,8 @@ func (o *node) getAlphaNodes(id *big.Int) []Contact {
 		return distance(arr[i].Id, id).Cmp(distance(arr[j].Id, id)) < 0
 	})
 	length := len(arr)
-	if length >= ALPHA {
-		for i := 0; i < ALPHA; i++ {
+	if length >= o.cfg.Alpha {
+		for i := 0; i < o.cfg.Alpha; i++ {
 			res = append(res, arr[i])
 		}
 	} else {
@@ -136,13 +177,15 @@ func (o *node) getAlphaNodes(id *big.Int) []Contact {
 	return res
 }
 
-func (o *node) iterativeFindNode(id *big.Int) []Contact {
+// iterativeFindNode returns the k closest nodes to id it finds before ctx ends
+func (o *node) iterativeFindNode(ctx context.Context, id *big.Int) []Contact {
 	var arr []Contact
 	MAP := make(map[string]bool)
 
 	que := o.getAlphaNodes(new(big.Int).Set(id))
+	defer func() { o.metrics.queried.Observe(float64(len(MAP))) }()
 	head := 0
-	for head < len(que) {
+	for head < len(que) && o.checkContext(ctx) == nil {
 		if MAP[que[head].Ip] == true {
 			head++
 			continue
@@ -151,22 +194,16 @@ func (o *node) iterativeFindNode(id *big.Int) []Contact {
 			MAP[que[head].Ip] = true
 			arr = append(arr, que[head])
 
-			client, err := Dial(que[head].Ip)
-			if err != nil {
-				fmt.Println("Error:", err)
-				continue
-			}
 			var res FindNodeReturn
-			err = client.Call("Node.RPCFindNode", FindNodeRequest{
+			err := o.call(ctx, que[head].Ip, "Node.RPCFindNode", FindNodeRequest{
 				Header: Contact{new(big.Int).Set(o.ID), o.IP},
 				Id:     id,
 			}, &res)
-			_ = client.Close()
 			if err != nil {
-				fmt.Println("Error:", err)
+				o.logger("find_node").Warn("find node failed", "peer", que[head].Ip, "err", err)
 				continue
 			}
-			go o.updateBucket(res.Header)
+			o.clock.Go(func() { o.updateBucket(res.Header) })
 			for _, v := range res.Closest {
 				que = append(que, v)
 			}
@@ -176,9 +213,9 @@ func (o *node) iterativeFindNode(id *big.Int) []Contact {
 	sort.Slice(arr, func(i, j int) bool {
 		return distance(arr[i].Id, id).Cmp(distance(arr[j].Id, id)) < 0
 	})
-	if len(arr) >= bucketSize {
+	if len(arr) >= o.cfg.BucketSize {
 		var res []Contact
-		for i := 0; i < bucketSize; i++ {
+		for i := 0; i < o.cfg.BucketSize; i++ {
 			res = append(res, arr[i])
 		}
 		return res
@@ -187,13 +224,20 @@ func (o *node) iterativeFindNode(id *big.Int) []Contact {
 	}
 }
 
-func (o *node) iterativeFindValue(arg FindValueRequest) (string, bool) {
+// iterativeFindValue looks up the value of a key, and returns ErrNotFound if the key
+// has no value or was deleted
+func (o *node) iterativeFindValue(ctx context.Context, arg FindValueRequest) ([]byte, error) {
 	var arr []Contact
+	var cached FindValueReturn // the latest cached copy found so far
 	MAP := make(map[string]bool)
 
 	que := o.getAlphaNodes(new(big.Int).Set(arg.HashId))
+	defer func() { o.metrics.queried.Observe(float64(len(MAP))) }()
 	head := 0
 	for head < len(que) {
+		if err := o.checkContext(ctx); err != nil {
+			return nil, err
+		}
 		if MAP[que[head].Ip] == true {
 			head++
 			continue
@@ -201,126 +245,205 @@ func (o *node) iterativeFindValue(arg FindValueRequest) (string, bool) {
 		if o.Ping(que[head].Ip) == true {
 			MAP[que[head].Ip] = true
 
-			client, err := Dial(que[head].Ip)
-			if err != nil {
-				fmt.Println("Error:", err)
-				continue
-			}
 			var res FindValueReturn
-			err = client.Call("Node.RPCFindValue", FindValueRequest{
+			err := o.call(ctx, que[head].Ip, "Node.RPCFindValue", FindValueRequest{
 				Header: Contact{new(big.Int).Set(o.ID), o.IP},
 				HashId: arg.HashId,
 				Key:    arg.Key,
 			}, &res)
-			_ = client.Close()
 			if err != nil {
-				fmt.Println("Error:", err)
+				o.logger("find_value").Warn("find value failed", "peer", que[head].Ip, "err", err)
 				continue
 			}
-			go o.updateBucket(res.Header)
+			o.clock.Go(func() { o.updateBucket(res.Header) })
 
-			if res.Closest == nil { // already get the value
+			if res.Found && res.verify(arg.Key) == false {
+				o.logger("find_value").Warn("pair not signed", "peer", que[head].Ip, "key", arg.Key)
+				res.Found = false
+			}
+			if res.Found && res.Cached == false { // already get the value, or its tombstone
+				trace.From(ctx).SetOwner(que[head].Ip)
+				if cached.Found && cached.Version > res.Version {
+					res = cached
+				}
 				sort.Slice(arr, func(i, j int) bool {
 					return distance(arr[i].Id, arg.HashId).Cmp(distance(arr[j].Id, arg.HashId)) < 0
 				})
 				if len(arr) > 0 { // for caching
-					go func() {
-						client, err := Dial(arr[0].Ip)
-						if err != nil {
-							fmt.Println("Error:", err)
-						}
+					cache := arr[0].Ip
+					req := StoreRequest{
+						Header:    Contact{new(big.Int).Set(o.ID), o.IP},
+						Pair:      KVPair{arg.Key, res.Val},
+						Expire:    o.clock.Now().Add(o.cfg.expire()),
+						Deleted:   res.Deleted,
+						Version:   res.Version,
+						Cached:    true,
+						Owner:     res.Owner,
+						Signature: res.Signature,
+					}
+					o.clock.Go(func() {
 						var storeReturn StoreReturn
-						err = client.Call("Node.RPCStore", StoreRequest{
-							Header: Contact{new(big.Int).Set(o.ID), o.IP},
-							Pair:   KVPair{arg.Key, res.Val},
-							Expire: time.Now().Add(tExpire),
-						}, &storeReturn)
-						_ = client.Close()
+						err := o.transport.Call(cache, "Node.RPCStore", req, &storeReturn)
 						if err != nil {
-							fmt.Println("Error:", err)
+							o.logger("find_value").Warn("cache failed", "peer", cache, "err", err)
+							return
 						}
-						go o.updateBucket(storeReturn.Header)
-					}()
+						o.updateBucket(storeReturn.Header)
+					})
 				}
-				return res.Val, true
-			} else { // value not found so far
-				for _, v := range res.Closest {
-					que = append(que, v)
+				if res.Deleted {
+					return nil, ErrNotFound
 				}
-				arr = append(arr, que[head])
+				return res.Val, nil
+			}
+			// value not found so far, or only a cached copy which a newer tombstone
+			// at the k closest nodes may replace
+			if res.Found && (cached.Found == false || res.Version > cached.Version) {
+				cached = res
+			}
+			for _, v := range res.Closest {
+				que = append(que, v)
 			}
+			arr = append(arr, que[head])
 		}
 		head++
 	}
-	return "", false
+	if cached.Found && cached.Deleted == false {
+		return cached.Val, nil
+	}
+	return nil, ErrNotFound
 }
 
-func (o *node) iterativeStore(arg StoreRequest) bool {
-	hash := hashString(arg.Pair.Key)
-	closest := o.iterativeFindNode(new(big.Int).Set(hash))
-	success := false
+// iterativeStore stores a pair at the k closest nodes to its key, and returns whether a node
+// stored it, whether a node has a newer version of the key and whether a node has a copy of
+// the key published by another node
+func (o *node) iterativeStore(ctx context.Context, arg StoreRequest) (bool, bool, bool) {
+	hash := o.hash(arg.Pair.Key)
+	closest := o.iterativeFindNode(ctx, new(big.Int).Set(hash))
+	arg.Header = Contact{new(big.Int).Set(o.ID), o.IP}
+	arg.Expire = o.clock.Now().Add(o.cfg.expire())
+	success, stale, denied := false, false, false
 	for _, t := range closest {
-		client, err := Dial(t.Ip)
-		if err != nil {
-			fmt.Println("Error:", err)
-			continue
+		if o.checkContext(ctx) != nil {
+			break
 		}
 		var res StoreReturn
-		err = client.Call("Node.RPCStore", StoreRequest{
-			Header: Contact{new(big.Int).Set(o.ID), o.IP},
-			Pair:   arg.Pair,
-			Expire: time.Now().Add(tExpire),
-		}, &res)
-		_ = client.Close()
+		err := o.call(ctx, t.Ip, "Node.RPCStore", arg, &res)
 		if err != nil {
-			fmt.Println("Error:", err)
+			o.logger("store").Warn("store failed", "peer", t.Ip, "err", err)
 			continue
 		}
-		go o.updateBucket(res.Header)
+		o.clock.Go(func() { o.updateBucket(res.Header) })
 		if res.Success == true {
+			if success == false {
+				trace.From(ctx).SetOwner(t.Ip) // the closest node which stored it
+			}
 			success = true
 		}
+		if res.Stale == true {
+			stale = true
+		}
+		if res.Denied == true {
+			denied = true
+		}
 	}
-	return success
+	return success, stale, denied
 }
 
-func (o *node) Publish(key, value string, firstTime bool) bool {
-	o.iterativeStore(StoreRequest{
-		Header: Contact{new(big.Int).Set(o.ID), o.IP},
-		Pair:   KVPair{key, value},
-		Expire: time.Now().Add(tExpire),
-	})
-	if firstTime == true {
-		o.publishMap.lock.Lock()
-		o.publishMap.Map[key] = ValueTimePair{
-			val:           value,
-			expireTime:    time.Now().Add(tExpire),
-			replicateTime: time.Time{},
+// storeError returns the error of a Publish or Delete which no node stored
+func (o *node) storeError(ctx context.Context, denied bool) error {
+	if err := o.checkContext(ctx); err != nil {
+		return err
+	}
+	if denied {
+		return ErrNotOwner
+	}
+	return ErrNotStored
+}
+
+// Publish stores a new version of a pair, signed by the node, at the k closest nodes to its key,
+// and republishes it until it is deleted. It returns ErrNotOwner if the key was published by
+// another node, and ErrNotStored if no node stored it before ctx ended
+func (o *node) Publish(ctx context.Context, key string, value []byte) (err error) {
+	defer func(start time.Time) { o.observe("put", start, err) }(o.clock.Now())
+	req := StoreRequest{
+		Pair:    KVPair{key, value},
+		Version: o.clock.Now().UnixNano(),
+	}
+	o.sign(&req)
+	o.publishMap.lock.Lock()
+	o.publishMap.Map[key] = ValueTimePair{
+		val:           value,
+		expireTime:    o.clock.Now().Add(o.cfg.expire()),
+		replicateTime: time.Time{},
+		version:       req.Version,
+		owner:         req.Owner,
+		signature:     req.Signature,
+	}
+	o.publishMap.lock.Unlock()
+
+	success, _, denied := o.iterativeStore(ctx, req)
+	if success == false {
+		if denied {
+			o.publishMap.lock.Lock()
+			delete(o.publishMap.Map, key)
+			o.publishMap.lock.Unlock()
 		}
-		o.publishMap.lock.Unlock()
+		return o.storeError(ctx, denied)
 	}
-	return true
+	return nil
 }
 
-func (o *node) GetValue(key string) (string, bool) {
+// Delete stores a tombstone of key, signed by the node, at the k closest nodes and stops
+// republishing it. It returns ErrNotFound if key had no value, ErrNotOwner if the key was
+// published by another node, and ErrNotStored if no other node stored the tombstone
+func (o *node) Delete(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) { o.observe("delete", start, err) }(o.clock.Now())
+	_, findErr := o.GetValue(ctx, key)
+	if findErr != nil && findErr != ErrNotFound {
+		return findErr
+	}
+
+	o.publishMap.lock.Lock()
+	delete(o.publishMap.Map, key)
+	o.publishMap.lock.Unlock()
+
+	req := StoreRequest{
+		Header:  Contact{new(big.Int).Set(o.ID), o.IP},
+		Pair:    KVPair{key, nil},
+		Expire:  o.clock.Now().Add(o.cfg.tombstone()),
+		Deleted: true,
+		Version: o.clock.Now().UnixNano(),
+	}
+	o.sign(&req)
+	_, err = o.store(req) // replaces the copy of the node, if any
+	if err != nil && err != errStale && err != errNoCopy {
+		o.logger("delete").Warn("store tombstone failed", "key", key, "err", err)
+	}
+	success, _, denied := o.iterativeStore(ctx, req)
+	if success == false && (findErr == nil || denied) {
+		return o.storeError(ctx, denied)
+	}
+	return findErr
+}
+
+// GetValue returns the value of key, or ErrNotFound if it has none
+func (o *node) GetValue(ctx context.Context, key string) (res []byte, err error) {
+	defer func(start time.Time) { o.observe("get", start, err) }(o.clock.Now())
 	o.Data.lock.Lock()
 	val, ok := o.Data.Map[key]
-	if ok == true {
-		o.Data.lock.Unlock()
-		return val.val, true
-	}
 	o.Data.lock.Unlock()
-	o.publishMap.lock.Lock()
-	val, ok = o.publishMap.Map[key]
-	if ok == true {
-		o.publishMap.lock.Unlock()
-		return val.val, true
+	if ok == true && val.cached == false {
+		trace.From(ctx).SetOwner(o.IP)
+		if val.deleted {
+			return nil, ErrNotFound
+		}
+		return val.val, nil
 	}
-	o.publishMap.lock.Unlock()
 
-	return o.iterativeFindValue(FindValueRequest{
+	return o.iterativeFindValue(ctx, FindValueRequest{
 		Header: Contact{new(big.Int).Set(o.ID), o.IP},
-		HashId: hashString(key),
+		HashId: o.hash(key),
 		Key:    key,
 	})
 }
@@ -332,13 +455,16 @@ func (o *node) Republish() {
 			if o.ON == false {
 				return
 			}
-			if time.Now().After(v.expireTime) {
-				o.Publish(k, v.val, false)
-				v.expireTime = time.Now().Add(tExpire)
+			if o.clock.Now().After(v.expireTime) {
+				_, stale, denied := o.iterativeStore(context.Background(), v.request(Contact{}, k))
+				if stale == true || denied == true { // replaced, or published by another node since the copies expired
+					delete(o.publishMap.Map, k)
+				}
+				v.expireTime = o.clock.Now().Add(o.cfg.expire())
 			}
 		}
 		o.publishMap.lock.Unlock()
-		time.Sleep(tRepublish)
+		o.clock.Sleep(o.cfg.republish())
 	}
 }
 
@@ -350,40 +476,58 @@ func (o *node) ExpireReplicate() {
 			if o.ON == false {
 				return
 			}
-			if time.Now().After(v.expireTime) {
+			if o.clock.Now().After(v.expireTime) {
 				delete(o.Data.Map, k)
-			} else if v.replicateTime.IsZero() == false && time.Now().After(v.replicateTime) {
-				replicate = append(replicate, StoreRequest{
-					Header: Contact{new(big.Int).Set(o.ID), o.IP},
-					Pair:   KVPair{k, v.val},
-					Expire: v.expireTime,
-				})
+			} else if v.replicateTime.IsZero() == false && o.clock.Now().After(v.replicateTime) {
+				replicate = append(replicate, v.request(Contact{new(big.Int).Set(o.ID), o.IP}, k))
+				v.replicateTime = time.Time{}
+				o.Data.Map[k] = v
 			}
 		}
 		o.Data.lock.Unlock()
 		for _, v := range replicate {
-			o.iterativeStore(v)
-			o.Data.Map[v.Pair.Key] = ValueTimePair{
-				val:           v.Pair.Val,
-				expireTime:    v.Expire,
-				replicateTime: time.Time{},
-			}
+			o.iterativeStore(context.Background(), v)
 		}
 
-		time.Sleep(tCheck)
+		o.clock.Sleep(o.cfg.check())
 	}
 }
 
 func (o *node) Refresh() {
 	for o.ON {
-		for i := 0; i < B; i++ {
+		for i := 0; i < len(o.kBuckets); i++ {
 			if o.ON == false {
 				return
 			}
-			if o.kBuckets[i].latestUpdate.Add(tRefresh).Before(time.Now()) {
-				o.iterativeFindNode(new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(i)), nil))
+			if o.kBuckets[i].latestUpdate.Add(o.cfg.refresh()).Before(o.clock.Now()) {
+				o.iterativeFindNode(context.Background(), new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(i)), nil))
 			}
 		}
-		time.Sleep(tCheck)
+		o.clock.Sleep(o.cfg.check())
+	}
+}
+
+// Dump prints the contacts and the stored pairs of the node
+func (o *node) Dump() {
+	fmt.Println("---------- DUMP ----------")
+	fmt.Println("IP:", o.IP)
+	fmt.Println("ID:", o.ID)
+	for i := range o.kBuckets {
+		b := &o.kBuckets[i]
+		b.mutex.Lock()
+		if b.size > 0 {
+			fmt.Println("Bucket", i, ":", b.arr[:b.size])
+		}
+		b.mutex.Unlock()
+	}
+	o.Data.lock.Lock()
+	for k, v := range o.Data.Map {
+		if v.deleted {
+			fmt.Println("Key:", k, "deleted, version", v.version)
+		} else {
+			fmt.Println("Key:", k, "Val:", string(v.val), "version", v.version)
+		}
 	}
+	o.Data.lock.Unlock()
+	fmt.Println("-------- DUMP END --------")
 }
@@ -3,30 +3,26 @@ package kademlia
 import (
 	"math/big"
 	"sort"
-	"time"
 )
 
 func (o *Node) RPCPing(p Contact, res *PingReturn) error {
-	go o.O.updateBucket(p)
+	o.O.clock.Go(func() { o.O.updateBucket(p) })
 	*res = PingReturn{Contact{new(big.Int).Set(o.O.ID), o.O.IP}, true}
 	return nil
 }
 
 func (o *Node) RPCStore(obj StoreRequest, res *StoreReturn) error {
-	go o.O.updateBucket(obj.Header)
-	o.O.Data.lock.Lock()
-	o.O.Data.Map[obj.Pair.Key] = ValueTimePair{
-		val:           obj.Pair.Val,
-		expireTime:    obj.Expire,
-		replicateTime: time.Now().Add(tReplicate),
+	o.O.clock.Go(func() { o.O.updateBucket(obj.Header) })
+	stored, err := o.O.store(obj)
+	if err != nil && err != errStale && err != errNoCopy {
+		o.O.logger("store").Warn("store rejected", "peer", obj.Header.Ip, "key", obj.Pair.Key, "err", err)
 	}
-	o.O.Data.lock.Unlock()
-	*res = StoreReturn{Contact{new(big.Int).Set(o.O.ID), o.O.IP}, true}
+	*res = StoreReturn{Contact{new(big.Int).Set(o.O.ID), o.O.IP}, stored, err == errStale, err == ErrNotOwner}
 	return nil
 }
 
 func (o *Node) RPCFindNode(arg FindNodeRequest, res *FindNodeReturn) error {
-	go o.O.updateBucket(arg.Header)
+	o.O.clock.Go(func() { o.O.updateBucket(arg.Header) })
 	res.Header = Contact{new(big.Int).Set(o.O.ID), o.O.IP}
 	res.Closest = make([]Contact, 0)
 	p := distance(arg.Id, o.O.ID).BitLen() - 1
@@ -34,8 +30,8 @@ func (o *Node) RPCFindNode(arg FindNodeRequest, res *FindNodeReturn) error {
 		p = 0
 	}
 	o.O.kBuckets[p].mutex.Lock()
-	if o.O.kBuckets[p].size == bucketSize {
-		for i := 0; i < bucketSize; i++ {
+	if o.O.kBuckets[p].size == o.O.cfg.BucketSize {
+		for i := 0; i < o.O.cfg.BucketSize; i++ {
 			res.Closest = append(res.Closest, o.O.kBuckets[p].arr[i])
 		}
 		o.O.kBuckets[p].mutex.Unlock()
@@ -43,7 +39,7 @@ func (o *Node) RPCFindNode(arg FindNodeRequest, res *FindNodeReturn) error {
 	}
 	o.O.kBuckets[p].mutex.Unlock()
 	var arr []Contact
-	for i := 0; i < B; i++ {
+	for i := 0; i < len(o.O.kBuckets); i++ {
 		o.O.kBuckets[i].mutex.Lock()
 		for j := 0; j < o.O.kBuckets[i].size; j++ {
 			arr = append(arr, o.O.kBuckets[i].arr[j])
@@ -54,8 +50,8 @@ func (o *Node) RPCFindNode(arg FindNodeRequest, res *FindNodeReturn) error {
 		return distance(arr[i].Id, arg.Id).Cmp(distance(arr[j].Id, arg.Id)) < 0
 	})
 	length := len(arr)
-	if length >= bucketSize {
-		for i := 0; i < bucketSize; i++ {
+	if length >= o.O.cfg.BucketSize {
+		for i := 0; i < o.O.cfg.BucketSize; i++ {
 			res.Closest = append(res.Closest, arr[i])
 		}
 	} else {
@@ -65,25 +61,32 @@ func (o *Node) RPCFindNode(arg FindNodeRequest, res *FindNodeReturn) error {
 }
 
 func (o *Node) RPCFindValue(arg FindValueRequest, res *FindValueReturn) error {
-	go o.O.updateBucket(arg.Header)
+	o.O.clock.Go(func() { o.O.updateBucket(arg.Header) })
 
 	value, ok := o.O.getValue(arg.Key)
 	if ok {
 		*res = FindValueReturn{
-			Header:  Contact{new(big.Int).Set(o.O.ID), o.O.IP},
-			Closest: nil,
-			Val:     value,
+			Header:    Contact{new(big.Int).Set(o.O.ID), o.O.IP},
+			Closest:   nil,
+			Found:     true,
+			Val:       value.val,
+			Deleted:   value.deleted,
+			Version:   value.version,
+			Cached:    value.cached,
+			Owner:     value.owner,
+			Signature: value.signature,
+		}
+		if value.cached == false {
+			return nil
 		}
-		return nil
 	}
 
 	res.Header = Contact{new(big.Int).Set(o.O.ID), o.O.IP}
 	res.Closest = make([]Contact, 0)
-	res.Val = ""
 	p := distance(arg.HashId, o.O.ID).BitLen() - 1
 	o.O.kBuckets[p].mutex.Lock()
-	if o.O.kBuckets[p].size == bucketSize {
-		for i := 0; i < bucketSize; i++ {
+	if o.O.kBuckets[p].size == o.O.cfg.BucketSize {
+		for i := 0; i < o.O.cfg.BucketSize; i++ {
 			res.Closest = append(res.Closest, o.O.kBuckets[p].arr[i])
 		}
 		o.O.kBuckets[p].mutex.Unlock()
@@ -91,7 +94,7 @@ func (o *Node) RPCFindValue(arg FindValueRequest, res *FindValueReturn) error {
 	}
 	o.O.kBuckets[p].mutex.Unlock()
 	var arr []Contact
-	for i := 0; i < B; i++ {
+	for i := 0; i < len(o.O.kBuckets); i++ {
 		o.O.kBuckets[i].mutex.Lock()
 		for j := 0; j < o.O.kBuckets[i].size; j++ {
 			arr = append(arr, o.O.kBuckets[i].arr[j])
@@ -102,8 +105,8 @@ func (o *Node) RPCFindValue(arg FindValueRequest, res *FindValueReturn) error {
 		return distance(arr[i].Id, arg.HashId).Cmp(distance(arr[j].Id, arg.HashId)) < 0
 	})
 	length := len(arr)
-	if length >= bucketSize {
-		for i := 0; i < bucketSize; i++ {
+	if length >= o.O.cfg.BucketSize {
+		for i := 0; i < o.O.cfg.BucketSize; i++ {
 			res.Closest = append(res.Closest, arr[i])
 		}
 	} else {
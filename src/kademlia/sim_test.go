@@ -0,0 +1,112 @@
+package kademlia_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"kademlia"
+	"log/slog"
+	"math/rand"
+	"metrics"
+	"reflect"
+	"simnet"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// function churn() runs joins, publishes, deletes and failures of n nodes on a simulated
+// network seeded with seed, and returns the virtual time the run took and what each read
+// saw, which only depend on seed. The run goes on in a goroutine of the clock, so it reports
+// errors with t.Error and returns
+func churn(t *testing.T, seed int64, n int) (time.Duration, []string) {
+	network := simnet.New(seed)
+	clock := network.Clock()
+	r := rand.New(rand.NewSource(seed))
+	reg := metrics.NewRegistry()
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	newNode := func(i int) *kademlia.Node {
+		addr := fmt.Sprintf("10.0.0.%d:2000", i)
+		o := kademlia.NewNode(addr, network.Endpoint(addr), kademlia.DefaultConfig())
+		o.SetLogger(discard)
+		o.SetMetrics(reg)
+		o.SetClock(clock)
+		err := o.Serve()
+		if err != nil {
+			t.Error("serve:", err)
+		}
+		return o
+	}
+	ctx := context.Background()
+	var reads []string
+	read := func(nodes []*kademlia.Node, keys []string) {
+		for _, k := range keys {
+			res, err := nodes[r.Intn(len(nodes))].O.GetValue(ctx, k)
+			reads = append(reads, fmt.Sprintf("%s=%s %v", k, res, err))
+		}
+	}
+	clock.Run(func() {
+		nodes := []*kademlia.Node{newNode(0)}
+		for i := 1; i < n; i++ {
+			o := newNode(i)
+			if o.O.Join(nodes[r.Intn(len(nodes))].O.IP) == false {
+				t.Error("join failed, seed", seed)
+				return
+			}
+			nodes = append(nodes, o)
+			clock.Sleep(100 * time.Millisecond)
+		}
+		clock.Sleep(10 * time.Second)
+
+		var keys []string
+		publisher := make(map[string]*kademlia.Node)
+		for i := 0; i < 2*n; i++ {
+			k := strconv.Itoa(i)
+			keys = append(keys, k)
+			publisher[k] = nodes[r.Intn(len(nodes))]
+			_ = publisher[k].O.Publish(ctx, k, []byte(k))
+		}
+		for _, k := range keys[:n/2] {
+			_ = publisher[k].O.Delete(ctx, k)
+		}
+		// only the publisher of a key may delete it
+		for _, k := range keys[n/2:] {
+			for _, o := range nodes {
+				if o == publisher[k] {
+					continue
+				}
+				if err := o.O.Delete(ctx, k); err != kademlia.ErrNotOwner {
+					t.Error("delete of", k, "by another node:", err)
+				}
+				break
+			}
+		}
+		read(nodes, keys)
+		for _, k := range keys[n/2:] {
+			if res, err := nodes[0].O.GetValue(ctx, k); err != nil || string(res) != k {
+				t.Error("get", k, "=", string(res), err, "after a delete by another node")
+			}
+		}
+
+		for i := 0; i < n/4; i++ {
+			p := 1 + r.Intn(len(nodes)-1)
+			_ = nodes[p].Stop()
+			nodes = append(nodes[:p], nodes[p+1:]...)
+		}
+		clock.Sleep(10 * time.Second)
+		read(nodes, keys)
+	})
+	return clock.Now().Sub(time.Unix(0, 0)), reads
+}
+
+func TestChurnDeterministic(t *testing.T) {
+	took, reads := churn(t, 5, 8)
+	took2, reads2 := churn(t, 5, 8)
+	if t.Failed() {
+		return
+	}
+	if took != took2 || reflect.DeepEqual(reads, reads2) == false {
+		t.Fatalf("two runs with seed 5 differ: %v %v, %v %v", took, reads, took2, reads2)
+	}
+}
@@ -0,0 +1,44 @@
+package kademlia
+
+// ContactState is a Contact with its ID in decimal, which JSON numbers cannot hold
+type ContactState struct {
+	Addr string `json:"addr"`
+	ID   string `json:"id"`
+}
+
+// BucketState is a non-empty k-bucket, its least-recently seen contact first
+type BucketState struct {
+	Index    int            `json:"index"`
+	Contacts []ContactState `json:"contacts"`
+}
+
+// NodeState is a snapshot of the routing table and keys of a node
+type NodeState struct {
+	Addr      string        `json:"addr"`
+	ID        string        `json:"id"`
+	ON        bool          `json:"on"`
+	Buckets   []BucketState `json:"buckets"`
+	Keys      int           `json:"keys"`
+	Published int           `json:"published"` // keys the node republishes
+}
+
+// State returns a snapshot of the state of the node
+func (o *Node) State() NodeState {
+	n := &o.O
+	res := NodeState{Addr: n.IP, ID: n.ID.String(), ON: n.ON}
+	for i := range n.kBuckets {
+		b := &n.kBuckets[i]
+		b.mutex.Lock()
+		if b.size > 0 {
+			bucket := BucketState{Index: i}
+			for _, c := range b.arr[:b.size] {
+				bucket.Contacts = append(bucket.Contacts, ContactState{c.Ip, c.Id.String()})
+			}
+			res.Buckets = append(res.Buckets, bucket)
+		}
+		b.mutex.Unlock()
+	}
+	res.Keys = n.Data.len()
+	res.Published = n.publishMap.len()
+	return res
+}
@@ -0,0 +1,121 @@
+// signed pairs and tombstones: a pair is signed by the node which published it, and a copy of
+// a key is only replaced by a pair signed by the same node until it expires, so that only the
+// publisher of a key may delete it. A deleted key is stored as a tombstone, which expires
+// Config.tombstone() after its version no matter how often it is stored again
+
+package kademlia
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	errStale  = errors.New("Newer version of the Key stored ")
+	errNoCopy = errors.New("No copy of the Key to delete ")
+)
+
+// signedDigest returns the bytes the signature of a version of a pair covers
+func signedDigest(key string, val []byte, deleted bool, version int64) []byte {
+	buf := make([]byte, 13, 13+len(key)+len(val))
+	binary.BigEndian.PutUint64(buf, uint64(version))
+	if deleted {
+		buf[8] = 1
+	}
+	binary.BigEndian.PutUint32(buf[9:], uint32(len(key)))
+	buf = append(buf, key...)
+	return append(buf, val...)
+}
+
+// verifySignature returns whether the version of a pair is signed by owner
+func verifySignature(owner ed25519.PublicKey, signature []byte, key string, val []byte, deleted bool, version int64) bool {
+	return len(owner) == ed25519.PublicKeySize &&
+		ed25519.Verify(owner, signedDigest(key, val, deleted, version), signature)
+}
+
+// sign signs the pair of req with the key of the node, which becomes its owner
+func (o *node) sign(req *StoreRequest) {
+	req.Owner = o.key.Public().(ed25519.PublicKey)
+	req.Signature = ed25519.Sign(o.key, signedDigest(req.Pair.Key, req.Pair.Val, req.Deleted, req.Version))
+}
+
+// verify returns whether a found pair is signed by its owner
+func (r FindValueReturn) verify(key string) bool {
+	return verifySignature(r.Owner, r.Signature, key, r.Val, r.Deleted, r.Version)
+}
+
+// tombstoneExpire returns when the tombstone of a version expires
+func (o *node) tombstoneExpire(version int64) time.Time {
+	return time.Unix(0, version).Add(o.cfg.tombstone())
+}
+
+// checkTombstone checks that a tombstone is not expired
+func (o *node) checkTombstone(req StoreRequest) error {
+	if o.clock.Now().After(o.tombstoneExpire(req.Version)) {
+		return errors.New("Tombstone of " + req.Pair.Key + " expired ")
+	}
+	return nil
+}
+
+// store keeps a pair unless the node has a newer version of its key (errStale), and returns
+// whether it did. The pair must be signed, and by the owner of the copy the node has, if not
+// expired, which a tombstone needs (errNoCopy) to check who may delete the key.
+// A tombstone replaces older values until it expires
+func (o *node) store(req StoreRequest) (bool, error) {
+	if verifySignature(req.Owner, req.Signature, req.Pair.Key, req.Pair.Val, req.Deleted, req.Version) == false {
+		return false, errors.New("Pair of " + req.Pair.Key + " not signed ")
+	}
+	if req.Deleted {
+		err := o.checkTombstone(req)
+		if err != nil {
+			return false, err
+		}
+		if expire := o.tombstoneExpire(req.Version); req.Expire.After(expire) {
+			req.Expire = expire
+		}
+	}
+	replicate := o.clock.Now().Add(o.cfg.replicate())
+	if req.Cached {
+		replicate = time.Time{} // cached copies are not replicated
+	}
+
+	o.Data.lock.Lock()
+	defer o.Data.lock.Unlock()
+	old, ok := o.Data.Map[req.Pair.Key]
+	ok = ok && o.clock.Now().After(old.expireTime) == false
+	switch {
+	case ok && bytes.Equal(old.owner, req.Owner) == false:
+		return false, ErrNotOwner
+	case ok && old.version > req.Version:
+		return false, errStale
+	case ok == false && req.Deleted:
+		return false, errNoCopy
+	}
+	o.Data.Map[req.Pair.Key] = ValueTimePair{
+		val:           req.Pair.Val,
+		expireTime:    req.Expire,
+		replicateTime: replicate,
+		deleted:       req.Deleted,
+		version:       req.Version,
+		cached:        req.Cached,
+		owner:         req.Owner,
+		signature:     req.Signature,
+	}
+	return true, nil
+}
+
+// request returns the StoreRequest which stores the pair at another node
+func (v ValueTimePair) request(header Contact, key string) StoreRequest {
+	return StoreRequest{
+		Header:    header,
+		Pair:      KVPair{key, v.val},
+		Expire:    v.expireTime,
+		Deleted:   v.deleted,
+		Version:   v.version,
+		Owner:     v.owner,
+		Signature: v.signature,
+	}
+}
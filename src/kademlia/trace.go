@@ -0,0 +1,18 @@
+package kademlia
+
+import (
+	"context"
+	"trace"
+)
+
+// call calls method on the node at addr, recording the call in the trace of ctx if any
+func (o *node) call(ctx context.Context, addr, method string, args, reply interface{}) error {
+	t := trace.From(ctx)
+	if t == nil {
+		return o.transport.Call(addr, method, args, reply)
+	}
+	start := o.clock.Now()
+	err := o.transport.Call(addr, method, args, reply)
+	t.Add(addr, method, o.clock.Now().Sub(start), err)
+	return err
+}
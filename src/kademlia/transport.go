@@ -0,0 +1,92 @@
+package kademlia
+
+import (
+	"net/rpc"
+	"rpcpool"
+	"time"
+)
+
+// Transport carries calls between kademlia nodes
+type Transport interface {
+	// Serve starts accepting calls for the methods of rcvr
+	Serve(rcvr interface{}) error
+	// Close stops accepting calls
+	Close() error
+	// Call invokes method (e.g. "Node.RPCPing") on the node at addr
+	Call(addr, method string, args, reply interface{}) error
+	// Ping checks whether the node at addr is reachable
+	Ping(addr string) bool
+}
+
+// Clock is where a node reads the time, waits and starts background work,
+// so that it can run on a simulated network with virtual time
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	Go(f func())
+}
+
+// RPCTransport is the net/rpc over TCP implementation of Transport.
+// It keeps one connection per peer, see rpcpool
+type RPCTransport struct {
+	listenAddr string
+	server     *rpc.Server
+	listener   *rpcpool.Listener
+	pool       *rpcpool.Pool
+}
+
+// NewRPCTransport returns a transport which listens on listenAddr, e.g. ":2000"
+func NewRPCTransport(listenAddr string) *RPCTransport {
+	return NewPooledTransport(listenAddr, rpcpool.DefaultOptions())
+}
+
+// NewPooledTransport returns a transport which listens on listenAddr,
+// with its connection pool tuned by opts
+func NewPooledTransport(listenAddr string, opts rpcpool.Options) *RPCTransport {
+	return &RPCTransport{listenAddr: listenAddr, server: rpc.NewServer(), pool: rpcpool.New(opts)}
+}
+
+func (t *RPCTransport) Serve(rcvr interface{}) error {
+	err := t.server.Register(rcvr)
+	if err != nil {
+		return err
+	}
+	listener, err := rpcpool.Listen(t.server, t.listenAddr)
+	if err != nil {
+		return err
+	}
+	t.listener = listener
+	return nil
+}
+
+// Close stops serving, dropping the connections of other nodes, and closes the pool
+func (t *RPCTransport) Close() error {
+	err := t.pool.Close()
+	if t.listener == nil {
+		return err
+	}
+	return t.listener.Close()
+}
+
+func (t *RPCTransport) Call(addr, method string, args, reply interface{}) error {
+	return t.pool.Call(addr, method, args, reply)
+}
+
+func (t *RPCTransport) Ping(addr string) bool {
+	return t.pool.Ping(addr)
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) Go(f func()) {
+	go f()
+}
@@ -1,10 +1,9 @@
 package kademlia
 
 import (
+	"crypto/ed25519"
 	"crypto/sha1"
 	"math/big"
-	"net"
-	"net/rpc"
 	"sync"
 	"time"
 )
@@ -21,18 +20,27 @@ type PingReturn struct {
 
 type KVPair struct {
 	Key string
-	Val string
+	Val []byte
 }
 
 type StoreRequest struct {
-	Header Contact
-	Pair   KVPair
-	Expire time.Time
+	Header  Contact
+	Pair    KVPair
+	Expire  time.Time
+	Deleted bool  // the pair is a tombstone
+	Version int64 // publish time in UnixNano, the latest version of a key wins
+	Cached  bool  // a copy cached by a lookup, not one of the k closest copies
+
+	// the node which published the pair, and its signature of the pair
+	Owner     ed25519.PublicKey
+	Signature []byte
 }
 
 type StoreReturn struct {
 	Header  Contact
 	Success bool
+	Stale   bool // the node has a newer version of the key
+	Denied  bool // the node has a copy of the key published by another node
 }
 
 type FindNodeRequest struct {
@@ -56,13 +64,25 @@ type Set map[string]struct{}
 type FindValueReturn struct {
 	Header  Contact
 	Closest []Contact
-	Val     string
+	Found   bool // Val is set, and Closest only if it is a cached copy
+	Val     []byte
+	Deleted bool
+	Version int64
+	Cached  bool // Val is a cached copy, Closest are given to go on with the lookup
+
+	Owner     ed25519.PublicKey
+	Signature []byte
 }
 
 type ValueTimePair struct {
-	val           string
+	val           []byte
 	expireTime    time.Time
 	replicateTime time.Time
+	deleted       bool
+	version       int64
+	cached        bool
+	owner         ed25519.PublicKey
+	signature     []byte
 }
 
 type KVMap struct {
@@ -70,19 +90,12 @@ type KVMap struct {
 	lock sync.Mutex
 }
 
-const (
-	bucketSize = 20
-	ALPHA      = 3
-	B          = 160
-)
-
-const (
-	tExpire    = time.Minute      // 24*time.Hour + 10*time.Second
-	tRepublish = time.Minute      // 24 * time.Hour
-	tRefresh   = 30 * time.Second // time.Hour
-	tReplicate = 30 * time.Second // time.Hour
-	tCheck     = 10 * time.Second // time.Minute
-)
+// len returns the number of pairs of the map
+func (m *KVMap) len() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.Map)
+}
 
 func distance(x, y *big.Int) *big.Int {
 	return new(big.Int).Xor(x, y)
@@ -94,79 +107,3 @@ func hashString(elt string) *big.Int {
 	hash.Write([]byte(elt))
 	return new(big.Int).SetBytes(hash.Sum(nil))
 }
-
-// function to get local address(ip address)
-func GetLocalAddress() string {
-	var localaddress string
-
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		panic("init: failed to find network interfaces")
-	}
-
-	// find the first non-loopback interface with an IP address
-	for _, elt := range ifaces {
-		if elt.Flags&net.FlagLoopback == 0 && elt.Flags&net.FlagUp != 0 {
-			addrs, err := elt.Addrs()
-			if err != nil {
-				panic("init: failed to get addresses for network interface")
-			}
-
-			for _, addr := range addrs {
-				ipnet, ok := addr.(*net.IPNet)
-				if ok {
-					if ip4 := ipnet.IP.To4(); len(ip4) == net.IPv4len {
-						localaddress = ip4.String()
-						break
-					}
-				}
-			}
-		}
-	}
-	if localaddress == "" {
-		panic("init: failed to find non-loopback interface with valid address on this node")
-	}
-
-	return localaddress
-}
-
-// function Dial() to dial a given address
-func Dial(addr string) (*rpc.Client, error) {
-	var err error
-	var client *rpc.Client
-	for i := 0; i < 3; i++ {
-		client, err = rpc.Dial("tcp", addr)
-		if err == nil {
-			return client, err
-		} else {
-			time.Sleep(time.Second / 2)
-		}
-	}
-	return nil, err
-}
-
-func Ping(addr string) bool {
-	for i := 0; i < 3; i++ {
-		chOK := make(chan bool)
-		go func() {
-			client, err := rpc.Dial("tcp", addr)
-			if err == nil {
-				err = client.Close()
-				chOK <- true
-			} else {
-				chOK <- false
-			}
-		}()
-		select {
-		case ok := <-chOK:
-			if ok {
-				return true
-			} else {
-				continue
-			}
-		case <-time.After(time.Second / 2):
-			break
-		}
-	}
-	return false
-}
@@ -4,11 +4,21 @@ package main
 
 import (
 	"bufio"
+	chord "chord"
+	"context"
+	"dht"
+	"errors"
 	"fmt"
 	"message"
+	"objstore"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+	"trace"
+	"unicode"
+	"unicode/utf8"
 )
 
 func getLine() []string {
@@ -19,32 +29,123 @@ func getLine() []string {
 		return nil
 	} else {
 		// fmt.Println(text)
-		args := strings.Fields(text)
+		args, err := splitArgs(text)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			return nil
+		}
 		return args
 	}
 }
 
+// function splitArgs() splits a command line into words. A word in double quotes may hold
+// spaces and Go escapes such as \n or \x00, e.g. put key "two words"
+func splitArgs(text string) ([]string, error) {
+	var args []string
+	for {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if text == "" {
+			return args, nil
+		}
+		if text[0] != '"' {
+			end := strings.IndexFunc(text, unicode.IsSpace)
+			if end < 0 {
+				end = len(text)
+			}
+			args, text = append(args, text[:end]), text[end:]
+			continue
+		}
+		end := 1
+		for end < len(text) && text[end] != '"' {
+			if text[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(text) {
+			return nil, errors.New("Unterminated quoted word ")
+		}
+		arg, err := strconv.Unquote(text[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid quoted word %s: %v ", text[:end+1], err)
+		}
+		args, text = append(args, arg), text[end+1:]
+	}
+}
+
+// function showValue() returns a value as typed on the command line, quoted unless it is one plain word
+func showValue(value []byte) string {
+	str := string(value)
+	if str == "" || utf8.Valid(value) == false || strings.IndexFunc(str, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPrint(r) == false || r == '"'
+	}) >= 0 {
+		return strconv.Quote(str)
+	}
+	return str
+}
+
 // function Help() shows information
 func Help() {
 	fmt.Printf("help info")
 	// TODO: complete Help()
 }
 
-// function Port() set the current port
-func Port(newPort string, port *int) {
-	portInt, err := strconv.Atoi(newPort)
+// function Bind() sets the address the node listens at, and the one other nodes reach it at.
+// args are a listen address or a bare port, then the advertised address if it differs
+func Bind(args []string, listen, advertise *string) {
+	adv := ""
+	if len(args) == 2 {
+		adv = args[1]
+	}
+	l, a, err := dht.ParseBind(args[0], adv)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		message.ShowMoreHelp()
+		return
+	}
+	*listen, *advertise = l, a
+
+	message.PrintTime()
+	fmt.Printf("bind: listen at %s, advertise %s\n", l, a)
+}
+
+// function Replicas() set the number of copies of each key in a new ring
+func Replicas(str string, replicas *int) {
+	n, err := strconv.Atoi(str)
 	if err != nil {
 		fmt.Println("Error: ", err)
 		message.ShowMoreHelp()
-	} else if portInt < 0 || portInt > 65535 {
-		fmt.Printf("Error: Invalid port\n")
+	} else if n < 1 {
+		fmt.Printf("Error: Invalid number of replicas\n")
 		message.ShowMoreHelp()
 	} else {
-		*port = portInt
+		*replicas = n
 
 		message.PrintTime()
-		fmt.Printf("port: set port to %d\n", portInt)
+		fmt.Printf("replicas: set replicas to %d\n", n)
+	}
+}
+
+// function Vnodes() sets the number of virtual nodes, or changes it once the node is in a ring
+func Vnodes(o *dhtNode, str string, createdOrJoined bool) {
+	n, err := strconv.Atoi(str)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		message.ShowMoreHelp()
+		return
+	} else if n < 1 {
+		fmt.Printf("Error: Invalid number of vnodes\n")
+		message.ShowMoreHelp()
+		return
 	}
+
+	if createdOrJoined && setVnodes(*o, n) == false {
+		fmt.Println("vnodes: cannot change vnodes to", n)
+		return
+	}
+	*vnodes = n
+	message.PrintTime()
+	fmt.Printf("vnodes: set vnodes to %d\n", n)
 }
 
 // function Create() creates a new chord ring based on the current node
@@ -65,28 +166,51 @@ func Join(o *dhtNode, addr string, createdOrJoined *bool) {
 	//fmt.Printf("join: join a ring containing %s\n", addr)
 }
 
-// function Quit()
-func Quit(o *dhtNode, createdOrJoined *bool) {
+// function Quit() returns false if the node could not leave, in which case it keeps running
+func Quit(o *dhtNode, createdOrJoined *bool) bool {
 	message.PrintTime()
 	if *createdOrJoined == false {
 		fmt.Println("quit")
-		return
+		return true
 	}
 
-	(*o).Quit()
+	err := (*o).Quit()
+	if err != nil {
+		fmt.Println("Error: quit: ", err)
+		fmt.Println("Enter \"quit\" to try again, the node keeps running until it hands its keys over")
+		return false
+	}
 	fmt.Println("quit")
+	return true
+}
+
+// opTimeout bounds every operation of the command line
+const opTimeout = 10 * time.Second
+
+// function opContext() returns the context of an operation of the command line
+func opContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), opTimeout)
+}
+
+// function opError() prints the error of an operation, ErrNotFound being no failure
+func opError(op string, err error) {
+	if errors.Is(err, dht.ErrNotFound) {
+		fmt.Println(op + ": Not Found")
+		return
+	}
+	fmt.Println("Error: "+op+": ", err)
 }
 
 func Put(o *dhtNode, key, value string) {
+	ctx, cancel := opContext()
+	defer cancel()
 	message.PrintTime()
-	success := (*o).Put(key, value)
-	if success == false {
-		fmt.Println("Put: cannot put", key, value)
+	err := (*o).Put(ctx, key, []byte(value))
+	if err != nil {
+		opError("Put", err)
 		return
 	}
-
-	//message.PrintTime()
-	//fmt.Println("Put: ", key, value)
+	fmt.Println("Put:", key, value)
 }
 
 func PutRandom(o *dhtNode, str string) {
@@ -96,39 +220,396 @@ func PutRandom(o *dhtNode, str string) {
 		return
 	}
 
-	for i := 0; i < n; i++ {
-		message.PrintTime()
-		key, value := randString(32), randString(32)
-		Put(o, key, value)
+	pairs := make([]dht.Pair, n)
+	for i := range pairs {
+		pairs[i] = dht.Pair{Key: randString(32), Value: []byte(randString(32))}
 	}
+	MultiPut(o, pairs)
 
 	message.PrintTime()
 	fmt.Println("Randomly put finished")
 }
 
+// function MultiPut() puts pairs in one batch and prints the outcome of each
+func MultiPut(o *dhtNode, pairs []dht.Pair) {
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	for i, res := range dht.MultiPut(ctx, *o, pairs) {
+		if res.Err != nil {
+			opError("Put "+res.Key, res.Err)
+		} else {
+			fmt.Println("Put:", res.Key, showValue(pairs[i].Value))
+		}
+	}
+}
+
+// function MultiGet() gets keys in one batch and prints the outcome of each
+func MultiGet(o *dhtNode, keys []string) {
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	for _, res := range dht.MultiGet(ctx, *o, keys) {
+		if res.Err != nil {
+			opError("Get "+res.Key, res.Err)
+		} else {
+			fmt.Println("Get:", res.Key, "=", showValue(res.Value))
+		}
+	}
+}
+
+// function MultiDelete() deletes keys in one batch and prints the outcome of each
+func MultiDelete(o *dhtNode, keys []string) {
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	for _, res := range dht.MultiDelete(ctx, *o, keys) {
+		if res.Err != nil {
+			opError("Delete "+res.Key, res.Err)
+		} else {
+			fmt.Println("Delete:", res.Key)
+		}
+	}
+}
+
 func Get(o *dhtNode, key string) {
+	ctx, cancel := opContext()
+	defer cancel()
 	message.PrintTime()
-	//value, success := o.O.Get(key)
-	success, _ := (*o).Get(key)
-	if success == false {
-		//fmt.Println("Get: Not Found: ", key)
+	value, err := (*o).Get(ctx, key)
+	if err != nil {
+		opError("Get", err)
 		return
 	}
-
-	//message.PrintTime()
-	//fmt.Println("Get: the key of ", key, " is ", value)
+	fmt.Println("Get:", key, "=", showValue(value))
 }
 
 func Delete(o *dhtNode, key string) {
+	ctx, cancel := opContext()
+	defer cancel()
 	message.PrintTime()
-	success := (*o).Del(key)
-	if success == false {
-		//fmt.Println("Delete: not found: ", key)
+	err := (*o).Del(ctx, key)
+	if err != nil {
+		opError("Delete", err)
 		return
 	}
+	fmt.Println("Delete:", key)
+}
 
-	//message.PrintTime()
-	//fmt.Println("Delete ", key)
+// function casNode() returns the node if its protocol has conditional writes
+func casNode(o *dhtNode) (dht.CASNode, bool) {
+	c, ok := (*o).(dht.CASNode)
+	if ok == false {
+		fmt.Println("Error: the protocol of the node has no conditional writes")
+	}
+	return c, ok
+}
+
+// function chordNode() returns the node if it is a chord node, which has consistency levels and lookups
+func chordNode(o *dhtNode) (*dht.ChordNode, bool) {
+	c, ok := (*o).(*dht.ChordNode)
+	if ok == false {
+		fmt.Println("Error: only chord nodes have consistency levels and lookups")
+	}
+	return c, ok
+}
+
+func GetVersion(o *dhtNode, key string) {
+	c, ok := casNode(o)
+	if ok == false {
+		return
+	}
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	value, version, err := c.GetWithVersion(ctx, key)
+	if err != nil {
+		opError("Get", err)
+		return
+	}
+	fmt.Println("Get:", key, "=", showValue(value), "version", version)
+}
+
+func PutIfAbsent(o *dhtNode, key, value string) {
+	c, ok := casNode(o)
+	if ok == false {
+		return
+	}
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	err := c.PutIfAbsent(ctx, key, []byte(value))
+	if err != nil {
+		opError("PutIfAbsent", err)
+		return
+	}
+	fmt.Println("PutIfAbsent:", key, value)
+}
+
+func CompareAndSwap(o *dhtNode, key, version, value string) {
+	c, ok := casNode(o)
+	if ok == false {
+		return
+	}
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	err := c.CompareAndSwap(ctx, key, version, []byte(value))
+	if err != nil {
+		opError("CompareAndSwap", err)
+		return
+	}
+	fmt.Println("CompareAndSwap:", key, value)
+}
+
+func DeleteIfVersion(o *dhtNode, key, version string) {
+	c, ok := casNode(o)
+	if ok == false {
+		return
+	}
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	err := c.DelIfVersion(ctx, key, version)
+	if err != nil {
+		opError("DeleteIfVersion", err)
+		return
+	}
+	fmt.Println("DeleteIfVersion:", key)
+}
+
+// function expirer() returns the node if its protocol has keys with a time to live
+func expirer(o *dhtNode) (dht.Expirer, bool) {
+	e, ok := (*o).(dht.Expirer)
+	if ok == false {
+		fmt.Println("Error: the protocol of the node has no TTLs")
+	}
+	return e, ok
+}
+
+// function parseTTL() parses a time to live, e.g. 30s
+func parseTTL(str string) (time.Duration, bool) {
+	ttl, err := time.ParseDuration(str)
+	if err == nil && ttl <= 0 {
+		err = dht.ErrInvalidTTL
+	}
+	if err != nil {
+		fmt.Println("Error: ", err)
+		message.ShowMoreHelp()
+		return 0, false
+	}
+	return ttl, true
+}
+
+func PutTTL(o *dhtNode, key, value, str string) {
+	e, ok := expirer(o)
+	if ok == false {
+		return
+	}
+	ttl, ok := parseTTL(str)
+	if ok == false {
+		return
+	}
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	err := e.PutTTL(ctx, key, []byte(value), ttl)
+	if err != nil {
+		opError("PutTTL", err)
+		return
+	}
+	fmt.Println("PutTTL:", key, value, "for", ttl)
+}
+
+func Expire(o *dhtNode, key, str string) {
+	e, ok := expirer(o)
+	if ok == false {
+		return
+	}
+	ttl, ok := parseTTL(str)
+	if ok == false {
+		return
+	}
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	err := e.Expire(ctx, key, ttl)
+	if err != nil {
+		opError("Expire", err)
+		return
+	}
+	fmt.Println("Expire:", key, "in", ttl)
+}
+
+// function parseLevel() parses the consistency level given after put, get or delete
+func parseLevel(str string) (chord.Consistency, bool) {
+	level, err := chord.ParseConsistency(str)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		message.ShowMoreHelp()
+		return level, false
+	}
+	return level, true
+}
+
+func PutLevel(o *dhtNode, key, value, str string) {
+	c, ok := chordNode(o)
+	if ok == false {
+		return
+	}
+	level, ok := parseLevel(str)
+	if ok == false {
+		return
+	}
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	err := c.PutLevel(ctx, key, []byte(value), level)
+	if err != nil {
+		opError("Put", err)
+		return
+	}
+	fmt.Println("Put:", key, value, "at", level)
+}
+
+func GetLevel(o *dhtNode, key, str string) {
+	c, ok := chordNode(o)
+	if ok == false {
+		return
+	}
+	level, ok := parseLevel(str)
+	if ok == false {
+		return
+	}
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	value, err := c.GetLevel(ctx, key, level)
+	if err != nil {
+		opError("Get", err)
+		return
+	}
+	fmt.Println("Get:", key, "=", showValue(value), "at", level)
+}
+
+func DeleteLevel(o *dhtNode, key, str string) {
+	c, ok := chordNode(o)
+	if ok == false {
+		return
+	}
+	level, ok := parseLevel(str)
+	if ok == false {
+		return
+	}
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	err := c.DelLevel(ctx, key, level)
+	if err != nil {
+		opError("Delete", err)
+		return
+	}
+	fmt.Println("Delete:", key, "at", level)
+}
+
+func Lookup(o *dhtNode, key string) {
+	c, ok := chordNode(o)
+	if ok == false {
+		return
+	}
+	ctx, cancel := opContext()
+	defer cancel()
+	message.PrintTime()
+	owner, path, err := c.Lookup(ctx, key)
+	if err != nil {
+		opError("Lookup", err)
+		return
+	}
+	fmt.Println("Lookup:", key, "at", owner, "path", strings.Join(path, " -> "))
+}
+
+// function Trace() runs a get, put or delete and prints the calls it made.
+// args are the operation and its arguments, e.g. "get key"
+func Trace(o *dhtNode, args []string) {
+	ctx, cancel := opContext()
+	defer cancel()
+	ctx, t := trace.New(ctx, args[0], args[1])
+	message.PrintTime()
+	switch {
+	case args[0] == "get" && len(args) == 2:
+		value, err := (*o).Get(ctx, args[1])
+		if err != nil {
+			opError("Get", err)
+		} else {
+			fmt.Println("Get:", args[1], "=", showValue(value))
+		}
+	case args[0] == "put" && len(args) == 3:
+		err := (*o).Put(ctx, args[1], []byte(args[2]))
+		if err != nil {
+			opError("Put", err)
+		} else {
+			fmt.Println("Put:", args[1], args[2])
+		}
+	case args[0] == "delete" && len(args) == 2:
+		err := (*o).Del(ctx, args[1])
+		if err != nil {
+			opError("Delete", err)
+		} else {
+			fmt.Println("Delete:", args[1])
+		}
+	default:
+		message.InvalidCommand()
+		return
+	}
+	fmt.Print(t)
+}
+
+// objTimeout bounds the upload or download of an object, which takes many operations
+const objTimeout = 5 * time.Minute
+
+// function PutFile() stores the file at path as an object under key, see objstore
+func PutFile(o *dhtNode, key, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return
+	}
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), objTimeout)
+	defer cancel()
+	message.PrintTime()
+	m, err := objstore.New(*o).Put(ctx, key, f)
+	if err != nil {
+		opError("PutFile", err)
+		return
+	}
+	fmt.Println("PutFile:", key, path, m.Size, "bytes in", len(m.Chunks), "chunks, sha256", m.Hash)
+}
+
+// function GetFile() writes the object stored under key to the file at path. The file is
+// written in full or not at all, so that an object failing its check is not left behind
+func GetFile(o *dhtNode, key, path string) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".part")
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return
+	}
+	defer os.Remove(f.Name())
+	ctx, cancel := context.WithTimeout(context.Background(), objTimeout)
+	defer cancel()
+	message.PrintTime()
+	m, err := objstore.New(*o).Get(ctx, key, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		opError("GetFile", err)
+		return
+	}
+	fmt.Println("GetFile:", key, path, m.Size, "bytes, sha256", m.Hash)
 }
 
 func Dump(o *dhtNode) {
@@ -144,7 +625,8 @@ func commandLine() {
 	//o.O = new(chord.Node)
 
 	//port := "7722" // abbr of PPCA
-	port := 1000
+	listen, advertise := ":1000", ""
+	replicas := nodeConfig.Chord.Replicas
 	createdOrJoined := false
 
 	//wg := new(sync.WaitGroup)
@@ -166,21 +648,39 @@ func commandLine() {
 			}
 
 		// commands before join or create
-		case "port":
+		case "bind", "port":
+			if len(args) != 2 && len(args) != 3 {
+				message.InvalidCommand()
+			} else if createdOrJoined {
+				message.HasJoined()
+			} else {
+				Bind(args[1:], &listen, &advertise)
+			}
+		case "replicas":
 			if len(args) != 2 {
 				message.InvalidCommand()
 			} else if createdOrJoined {
 				message.HasJoined()
 			} else {
-				Port(args[1], &port)
+				Replicas(args[1], &replicas)
+			}
+		case "vnodes":
+			if len(args) != 2 {
+				message.InvalidCommand()
+			} else {
+				Vnodes(&o, args[1], createdOrJoined)
 			}
 		case "create":
 			if len(args) != 1 {
 				message.InvalidCommand()
 			} else if createdOrJoined {
 				message.HasJoined()
+			} else if node, err := NewNode(listen, advertise); err != nil {
+				fmt.Println("Error: ", err)
+				message.ShowMoreHelp()
 			} else {
-				o = NewNode(port)
+				o = node
+				setReplicas(o, replicas)
 				o.Run()
 				Create(&o, &createdOrJoined)
 			}
@@ -189,8 +689,11 @@ func commandLine() {
 				message.InvalidCommand()
 			} else if createdOrJoined {
 				message.HasJoined()
+			} else if node, err := NewNode(listen, advertise); err != nil {
+				fmt.Println("Error: ", err)
+				message.ShowMoreHelp()
 			} else {
-				o = NewNode(port)
+				o = node
 				o.Run()
 				Join(&o, args[1], &createdOrJoined)
 			}
@@ -200,16 +703,17 @@ func commandLine() {
 			if len(args) != 1 {
 				message.InvalidCommand()
 			} else {
-				Quit(&o, &createdOrJoined)
-				running = false
+				running = Quit(&o, &createdOrJoined) == false
 			}
 
 		// put putRandom get delete
 		case "put":
-			if len(args) != 3 {
-				message.InvalidCommand()
-			} else {
+			if len(args) == 3 {
 				Put(&o, args[1], args[2])
+			} else if len(args) == 4 {
+				PutLevel(&o, args[1], args[2], args[3])
+			} else {
+				message.InvalidCommand()
 			}
 		case "putrandom":
 			if len(args) != 2 {
@@ -218,16 +722,112 @@ func commandLine() {
 				PutRandom(&o, args[1])
 			}
 		case "get":
+			if len(args) == 2 {
+				Get(&o, args[1])
+			} else if len(args) == 3 {
+				GetLevel(&o, args[1], args[2])
+			} else {
+				message.InvalidCommand()
+			}
+		case "delete":
+			if len(args) == 2 {
+				Delete(&o, args[1])
+			} else if len(args) == 3 {
+				DeleteLevel(&o, args[1], args[2])
+			} else {
+				message.InvalidCommand()
+			}
+
+		// batches
+		case "mput":
+			if len(args) < 3 || len(args)%2 == 0 {
+				message.InvalidCommand()
+			} else {
+				var pairs []dht.Pair
+				for i := 1; i < len(args); i += 2 {
+					pairs = append(pairs, dht.Pair{Key: args[i], Value: []byte(args[i+1])})
+				}
+				MultiPut(&o, pairs)
+			}
+		case "mget":
+			if len(args) < 2 {
+				message.InvalidCommand()
+			} else {
+				MultiGet(&o, args[1:])
+			}
+		case "mdelete":
+			if len(args) < 2 {
+				message.InvalidCommand()
+			} else {
+				MultiDelete(&o, args[1:])
+			}
+
+		// conditional writes
+		case "getversion":
 			if len(args) != 2 {
 				message.InvalidCommand()
 			} else {
-				Get(&o, args[1])
+				GetVersion(&o, args[1])
 			}
-		case "delete":
+		case "putifabsent":
+			if len(args) != 3 {
+				message.InvalidCommand()
+			} else {
+				PutIfAbsent(&o, args[1], args[2])
+			}
+		case "cas":
+			if len(args) != 4 {
+				message.InvalidCommand()
+			} else {
+				CompareAndSwap(&o, args[1], args[2], args[3])
+			}
+		case "deleteifversion":
+			if len(args) != 3 {
+				message.InvalidCommand()
+			} else {
+				DeleteIfVersion(&o, args[1], args[2])
+			}
+
+		// keys with a time to live
+		case "putttl":
+			if len(args) != 4 {
+				message.InvalidCommand()
+			} else {
+				PutTTL(&o, args[1], args[2], args[3])
+			}
+		case "expire":
+			if len(args) != 3 {
+				message.InvalidCommand()
+			} else {
+				Expire(&o, args[1], args[2])
+			}
+
+		// objects
+		case "putfile":
+			if len(args) != 3 {
+				message.InvalidCommand()
+			} else {
+				PutFile(&o, args[1], args[2])
+			}
+		case "getfile":
+			if len(args) != 3 {
+				message.InvalidCommand()
+			} else {
+				GetFile(&o, args[1], args[2])
+			}
+
+		case "lookup":
 			if len(args) != 2 {
 				message.InvalidCommand()
 			} else {
-				Delete(&o, args[1])
+				Lookup(&o, args[1])
+			}
+
+		case "trace":
+			if len(args) < 3 {
+				message.InvalidCommand()
+			} else {
+				Trace(&o, args[1:])
 			}
 
 		// dump
@@ -1,12 +1,74 @@
 package main
 
 import (
+	"dht"
+	"flag"
 	"log"
+	"log/slog"
+	"metrics"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 )
 
+var (
+	simNodes  = flag.Int("sim", 0, "run the test with this many nodes on a simulated network")
+	simSeed   = flag.Int64("seed", 1, "seed of the simulated network")
+	replicas  = flag.Int("replicas", 0, "copies of each key in the simulated ring, the config's if 0")
+	vnodes    = flag.Int("vnodes", 1, "virtual nodes of each chord node")
+	dataDir   = flag.String("data", "", "directory where a node keeps its keys across restarts, memory only if empty")
+	protocol  = flag.String("protocol", "chord", "protocol of the nodes, chord or kademlia")
+	confPath  = flag.String("config", "", "JSON file of the protocol parameters, the defaults if empty")
+	testHost  = flag.String("host", "", "IP address the nodes of the network test listen at, e.g. 127.0.0.1, every interface if empty")
+	logLevel  = flag.String("log-level", "info", "least level of the log entries of the nodes: debug, info, warn or error")
+	logFmt    = flag.String("log-format", "text", "format of the log entries of the nodes on stderr, text or json")
+	metricsAt = flag.String("metrics", "", "address to serve the metrics of the nodes at /metrics, e.g. :9100, none if empty")
+	httpAt    = flag.String("http", "", "address to serve a node over HTTP at, e.g. 127.0.0.1:8080, instead of running the test; set $DHT_ADMIN_TOKEN to allow /admin/create, join and leave from other hosts")
+	respAt    = flag.String("resp", "", "address to serve a node to Redis clients at, e.g. :6379, instead of running the test")
+	listenAt  = flag.String("listen", ":1000", "address the served node listens at, see bind")
+	advertise = flag.String("advertise", "", "address other nodes reach the served node at, see bind")
+	joinAddr  = flag.String("join", "", "node of the ring the served node joins, if empty it waits for /admin/create or /admin/join with -http, or creates a ring")
+)
+
+// parameters of the nodes, from -config
+var nodeConfig = dht.DefaultConfig()
+
 func main() {
+	flag.Parse()
+	logger, err := dht.NewLogger(os.Stderr, *logFmt, *logLevel)
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+	slog.SetDefault(logger)
+	if *metricsAt != "" {
+		serveMetrics(*metricsAt)
+	}
+	if *protocol != "chord" && *protocol != "kademlia" {
+		log.Fatalln("Error: unknown protocol", *protocol)
+	}
+	if *confPath != "" {
+		nodeConfig, err = dht.LoadConfig(*confPath)
+		if err != nil {
+			log.Fatalln("Error:", err)
+		}
+	}
+	if *replicas > 0 {
+		nodeConfig.Chord.Replicas = *replicas
+	}
+	if *simNodes > 0 && *protocol == "kademlia" {
+		kadSimTest(*simSeed, *simNodes, nodeConfig.Kademlia)
+		return
+	}
+	if *simNodes > 0 {
+		simTest(*simSeed, *simNodes, *vnodes, nodeConfig.Chord)
+		return
+	}
+
+	if *httpAt != "" || *respAt != "" {
+		serveNode(*httpAt, *respAt, *listenAt, *advertise, *joinAddr)
+		return
+	}
+
 	go func() {
 		log.Println(http.ListenAndServe("localhost:8888", nil))
 	}()
@@ -15,3 +77,15 @@ func main() {
 	test()
 	//fmt.Println("I'm not reporting anymore.")
 }
+
+// function serveMetrics() serves the metrics of the nodes of the process at addr/metrics
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			slog.Error("serve metrics failed", "addr", addr, "err", err)
+		}
+	}()
+}
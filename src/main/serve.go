@@ -0,0 +1,68 @@
+// a node served over HTTP (see httpapi) and to Redis clients (see resp)
+
+package main
+
+import (
+	"httpapi"
+	"log"
+	"log/slog"
+	"metrics"
+	"os"
+	"os/signal"
+	"resp"
+)
+
+// function serveNode() serves a node listening at listen over HTTP at httpAddr and to Redis
+// clients at respAddr, each unless empty, until the node leaves its ring. The node joins the
+// ring of join, or without it creates a ring unless it is served over HTTP, which can do either.
+// The admin operations over HTTP take the token in $DHT_ADMIN_TOKEN, see httpapi.Server.
+// An interrupt makes the node leave
+func serveNode(httpAddr, respAddr, listen, advertise, join string) {
+	node, err := NewNode(listen, advertise)
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+	node.Run()
+	s := httpapi.New(node, metrics.Default, opTimeout)
+	s.AdminToken = os.Getenv("DHT_ADMIN_TOKEN")
+	switch {
+	case join != "":
+		err = s.Join(join)
+	case httpAddr == "":
+		err = s.Create()
+	}
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+	if httpAddr != "" {
+		go func() {
+			err := httpapi.NewHTTPServer(httpAddr, s).ListenAndServe()
+			if err != nil {
+				log.Fatalln("Error:", err)
+			}
+		}()
+		slog.Info("serving the node over HTTP", "addr", httpAddr, "node", node.GetAddr())
+	}
+	if respAddr != "" {
+		r := resp.New(node, opTimeout)
+		defer r.Close()
+		go func() {
+			err := r.ListenAndServe(respAddr)
+			if err != nil {
+				log.Fatalln("Error:", err)
+			}
+		}()
+		slog.Info("serving the node to Redis clients", "addr", respAddr, "node", node.GetAddr())
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	select {
+	case <-s.Done():
+	case <-interrupt:
+		err := s.Leave()
+		if err != nil {
+			node.ForceQuit()
+		}
+	}
+}
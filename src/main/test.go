@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bytes"
 	chord "chord"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"kademlia"
 	"log"
 	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"simnet"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,7 +39,9 @@ func KVTest() {
 		MAP[str] = str
 		p := rand.Int() % id
 		//(*node[p]).Put(k, v)
-		node[p].Put(str, str)
+		if err := node[p].Put(context.Background(), str, []byte(str)); err != nil {
+			fmt.Println("Error: Put", str, err)
+		}
 		PUT++
 	}
 
@@ -38,8 +50,8 @@ func KVTest() {
 	cnt := 0
 	for k, v := range MAP {
 		p := rand.Int() % id
-		_, res := node[p].Get(k)
-		if res != v {
+		res, _ := node[p].Get(context.Background(), k)
+		if string(res) != v {
 			log.Fatalln("Get incorrect when get key", k)
 		}
 		cnt++
@@ -60,7 +72,9 @@ func KVTest() {
 		}
 	}
 	for _, k := range str {
-		node[rand.Int()%id].Del(k)
+		if err := node[rand.Int()%id].Del(context.Background(), k); err != nil {
+			fmt.Println("Error: Delete", k, err)
+		}
 		delete(MAP, k)
 	}
 
@@ -68,6 +82,15 @@ func KVTest() {
 	time.Sleep(5 * second)
 }
 
+// function newTestNode() returns a node of the network test at port of -host
+func newTestNode(port int) dhtNode {
+	o, err := NewNode(net.JoinHostPort(*testHost, strconv.Itoa(port)), "")
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+	return o
+}
+
 func test() {
 	//randomInit()
 	rand.Seed(1)
@@ -75,19 +98,17 @@ func test() {
 
 	id = 0
 
-	node[id] = NewNode(2000)
+	node[id] = newTestNode(2000)
 	node[id].Run()
 	node[id].Create()
 	id++
 
-	localAddr := chord.GetLocalAddress()
-
 	for t := 0; t < 5; t++ {
 		fmt.Println("Start to test join")
 		for i := 0; i < 15; i++ {
-			node[id] = NewNode(id + 2000)
+			node[id] = newTestNode(id + 2000)
 			node[id].Run()
-			node[id].Join(localAddr + ":" + strconv.Itoa(2000+rand.Int()%id))
+			node[id].Join(node[rand.Int()%id].GetAddr())
 			id++
 
 			time.Sleep(1 * second)
@@ -103,7 +124,10 @@ func test() {
 
 		fmt.Println("Start to test quit")
 		for i := 5; i >= 1; i-- {
-			node[id-i].Quit()
+			err := node[id-i].Quit()
+			if err != nil {
+				log.Fatalln("Error: quit", err)
+			}
 			time.Sleep(2 * second)
 		}
 		id -= 5
@@ -121,3 +145,353 @@ func test() {
 }
 
 // AFuLtLjPNW
+
+// function simTest() runs joins, k-v checks and quits of n chord hosts with vnodes
+// virtual nodes each on a simulated network, so that a run only depends on seed
+func simTest(seed int64, n, vnodes int, cfg chord.Config) {
+	network := simnet.New(seed)
+	clock := network.Clock()
+	r := rand.New(rand.NewSource(seed))
+
+	dir, err := ioutil.TempDir("", "chord-sim")
+	if err != nil {
+		log.Fatalln("Error: TempDir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	addrOf := func(i int) string {
+		return fmt.Sprintf("10.0.%d.%d:2000", i/256, i%256)
+	}
+	// a host at an address used before reloads the keys it kept
+	setup := func(o *chord.Node, i int) {
+		o.SetClock(clock)
+		data, err := chord.OpenFileStorage(filepath.Join(dir, o.Addr, "data"))
+		if err != nil {
+			log.Fatalln("Error: OpenFileStorage", err)
+		}
+		dataPre, err := chord.OpenFileStorage(filepath.Join(dir, o.Addr, "datapre"))
+		if err != nil {
+			log.Fatalln("Error: OpenFileStorage", err)
+		}
+		// the simulated crashes stop a host, not the machine, so the page cache keeps the log
+		data.Sync, dataPre.Sync = false, false
+		o.SetStorage(data, dataPre)
+	}
+	newHost := func(addr string) *chord.Host {
+		h := chord.NewHost(addr, network.Endpoint(addr), vnodes, cfg)
+		h.Setup = setup
+		err := h.Serve()
+		if err != nil {
+			log.Fatalln("Error: Serve", err)
+		}
+		return h
+	}
+	ctx := context.Background()
+	pick := func(hosts []*chord.Host) *chord.Node {
+		h := hosts[r.Intn(len(hosts))]
+		return h.Nodes[r.Intn(len(h.Nodes))]
+	}
+	check := func(hosts []*chord.Host, keys []string) {
+		for _, k := range keys {
+			res, err := pick(hosts).Get(ctx, k)
+			if err != nil || string(res) != k {
+				log.Fatalln("Get incorrect when get key", k, "seed", seed)
+			}
+		}
+	}
+	checkDeleted := func(hosts []*chord.Host, deleted []string) {
+		for _, k := range deleted {
+			if _, err := pick(hosts).Get(ctx, k); errors.Is(err, chord.ErrNotFound) == false {
+				log.Fatalln("Deleted key", k, "came back, seed", seed)
+			}
+		}
+	}
+
+	clock.Run(func() {
+		hosts := []*chord.Host{newHost(addrOf(0))}
+		hosts[0].Create()
+
+		fmt.Println("Start to test join")
+		for i := 1; i < n; i++ {
+			h := newHost(addrOf(i))
+			h.Join(pick(hosts).Addr)
+			hosts = append(hosts, h)
+			clock.Sleep(100 * time.Millisecond)
+		}
+		clock.Sleep(10 * second)
+
+		fmt.Println("Start to test insert")
+		var keys []string
+		for i := 0; i < 3*n; i++ {
+			k := strconv.Itoa(i)
+			keys = append(keys, k)
+			if err := pick(hosts).Put(ctx, k, []byte(k)); err != nil {
+				log.Fatalln("Put failed when put key", k, err, "seed", seed)
+			}
+		}
+		check(hosts, keys)
+
+		fmt.Println("Start to test binary values")
+		binary := make([]byte, 1<<16)
+		r.Read(binary)
+		if err := pick(hosts).Put(ctx, "binary", binary); err != nil {
+			log.Fatalln("Put failed when put a binary value", err, "seed", seed)
+		}
+		if res, err := pick(hosts).Get(ctx, "binary"); err != nil || bytes.Equal(res, binary) == false {
+			log.Fatalln("Get incorrect when get a binary value", err, "seed", seed)
+		}
+
+		fmt.Println("Start to test batches")
+		var batch []chord.KVPair
+		var batchKeys []string
+		for i := 0; i < 2*n; i++ {
+			k := "batch" + strconv.Itoa(i)
+			batch = append(batch, chord.KVPair{Key: k, Value: []byte(k)})
+			batchKeys = append(batchKeys, k)
+		}
+		for _, res := range pick(hosts).MultiPut(ctx, batch) {
+			if res.Err != nil {
+				log.Fatalln("MultiPut failed when put key", res.Key, res.Err, "seed", seed)
+			}
+		}
+		for _, res := range pick(hosts).MultiGet(ctx, batchKeys) {
+			if res.Err != nil || string(res.Value) != res.Key {
+				log.Fatalln("MultiGet incorrect when get key", res.Key, res.Err, "seed", seed)
+			}
+		}
+		for _, res := range pick(hosts).MultiDelete(ctx, batchKeys[:n]) {
+			if res.Err != nil {
+				log.Fatalln("MultiDelete failed when delete key", res.Key, res.Err, "seed", seed)
+			}
+		}
+		checkDeleted(hosts, batchKeys[:n])
+		check(hosts, batchKeys[n:])
+
+		fmt.Println("Start to test compare-and-swap")
+		if pick(hosts).PutIfAbsent(ctx, "counter", []byte("0")) != nil ||
+			errors.Is(pick(hosts).PutIfAbsent(ctx, "counter", []byte("1")), chord.ErrConditionFailed) == false {
+			log.Fatalln("PutIfAbsent incorrect, seed", seed)
+		}
+		done := 0
+		for i := 0; i < 5; i++ {
+			o := pick(hosts)
+			clock.Go(func() {
+				for j := 0; j < 4; j++ {
+					for {
+						value, version, _ := o.GetWithVersion(ctx, "counter")
+						cnt, _ := strconv.Atoi(string(value))
+						if o.CompareAndSwap(ctx, "counter", version, []byte(strconv.Itoa(cnt+1))) == nil {
+							break
+						}
+					}
+				}
+				done++
+			})
+		}
+		for done < 5 {
+			clock.Sleep(time.Second)
+		}
+		if value, _ := pick(hosts).Get(ctx, "counter"); string(value) != "20" {
+			log.Fatalln("Compare-and-swap incorrect, counter", string(value), "seed", seed)
+		}
+
+		fmt.Println("Start to test delete")
+		deleted := keys[:n/2]
+		keys = keys[n/2:]
+		for _, k := range deleted {
+			if err := pick(hosts).Delete(ctx, k); err != nil {
+				log.Fatalln("Delete failed when delete key", k, err, "seed", seed)
+			}
+		}
+		checkDeleted(hosts, deleted)
+		if err := pick(hosts).Delete(ctx, deleted[0]); errors.Is(err, chord.ErrNotFound) == false {
+			log.Fatalln("Delete of a deleted key returned", err, "seed", seed)
+		}
+
+		fmt.Println("Start to test deadlines")
+		expired, cancel := context.WithDeadline(ctx, clock.Now())
+		_, err := pick(hosts).Get(expired, keys[0])
+		cancel()
+		if errors.Is(err, chord.ErrTimeout) == false {
+			log.Fatalln("Get past its deadline returned", err, "seed", seed)
+		}
+
+		fmt.Println("Start to test TTLs")
+		var ttlKeys []string
+		for i := 0; i < n/2; i++ {
+			k := "ttl" + strconv.Itoa(i)
+			ttlKeys = append(ttlKeys, k)
+			if err := pick(hosts).PutWithTTL(ctx, k, []byte(k), 15*time.Second); err != nil {
+				log.Fatalln("PutWithTTL failed when put key", k, err, "seed", seed)
+			}
+		}
+		check(hosts, ttlKeys)
+
+		fmt.Println("Start to test vnodes")
+		for i := 0; i < n/5; i++ {
+			h := hosts[r.Intn(len(hosts))]
+			if !h.SetVnodes(vnodes + 2) {
+				log.Fatalln("SetVnodes failed, seed", seed)
+			}
+			clock.Sleep(time.Second)
+		}
+		clock.Sleep(10 * second)
+		check(hosts, keys)
+		for _, h := range hosts {
+			h.SetVnodes(vnodes)
+			clock.Sleep(time.Second)
+		}
+		clock.Sleep(10 * second)
+		check(hosts, keys)
+		checkDeleted(hosts, deleted)
+		checkDeleted(hosts, ttlKeys)
+
+		fmt.Println("Start to test quit")
+		for i := 0; i < n/5; i++ {
+			p := 1 + r.Intn(len(hosts)-1)
+			err := hosts[p].Quit()
+			if err != nil {
+				log.Fatalln("Error: quit", err, "seed", seed)
+			}
+			_ = hosts[p].Stop()
+			hosts = append(hosts[:p], hosts[p+1:]...)
+			clock.Sleep(time.Second)
+		}
+		clock.Sleep(10 * second)
+		check(hosts, keys)
+		checkDeleted(hosts, deleted)
+
+		fmt.Println("Start to test force quit")
+		for i := 0; i < n/10; i++ {
+			// replicas-1 adjacent hosts fail at once
+			addr := hosts[1+r.Intn(len(hosts)-1)].Addr
+			for j := 1; j < cfg.Replicas; j++ {
+				p := 1
+				for p < len(hosts) && hosts[p].Addr != strings.Split(addr, "#")[0] {
+					p++
+				}
+				if p == len(hosts) {
+					break
+				}
+				addr = hosts[p].Nodes[0].Successor[1].Addr
+				_ = hosts[p].Stop()
+				hosts = append(hosts[:p], hosts[p+1:]...)
+			}
+			clock.Sleep(10 * second)
+		}
+		check(hosts, keys)
+		checkDeleted(hosts, deleted)
+
+		fmt.Println("Start to test restart")
+		for i := 0; i < n/10; i++ {
+			p := 1 + r.Intn(len(hosts)-1)
+			addr := hosts[p].Addr
+			_ = hosts[p].Stop()
+			hosts = append(hosts[:p], hosts[p+1:]...)
+			clock.Sleep(10 * second)
+
+			h := newHost(addr)
+			h.Join(pick(hosts).Addr)
+			hosts = append(hosts, h)
+			clock.Sleep(10 * second)
+		}
+		check(hosts, keys)
+		checkDeleted(hosts, deleted)
+	})
+	fmt.Println("Simulated test passed, virtual time", clock.Now().Sub(time.Unix(0, 0)))
+}
+
+// function kadSimTest() runs joins, puts, gets, deletes and quits of n kademlia nodes on a
+// simulated network, so that a run only depends on seed
+func kadSimTest(seed int64, n int, cfg kademlia.Config) {
+	network := simnet.New(seed)
+	clock := network.Clock()
+	r := rand.New(rand.NewSource(seed))
+
+	newNode := func(i int) *kademlia.Node {
+		addr := fmt.Sprintf("10.0.%d.%d:2000", i/256, i%256)
+		o := kademlia.NewNode(addr, network.Endpoint(addr), cfg)
+		o.SetClock(clock)
+		err := o.Serve()
+		if err != nil {
+			log.Fatalln("Error: Serve", err)
+		}
+		return o
+	}
+	ctx := context.Background()
+	check := func(nodes []*kademlia.Node, keys []string, deleted map[string]bool) {
+		miss, stale := 0, 0
+		for _, k := range keys {
+			res, err := nodes[r.Intn(len(nodes))].O.GetValue(ctx, k)
+			if deleted[k] {
+				if err != kademlia.ErrNotFound {
+					stale++
+				}
+			} else if err != nil || string(res) != k {
+				miss++
+			}
+		}
+		fmt.Println("Get incorrect:", miss, "of", len(keys)-len(deleted), ", deleted keys found:", stale, "of", len(deleted))
+	}
+
+	clock.Run(func() {
+		nodes := []*kademlia.Node{newNode(0)}
+
+		fmt.Println("Start to test join")
+		for i := 1; i < n; i++ {
+			o := newNode(i)
+			if o.O.Join(nodes[r.Intn(len(nodes))].O.IP) == false {
+				log.Fatalln("Error: Join failure at", o.O.IP)
+			}
+			nodes = append(nodes, o)
+			clock.Sleep(100 * time.Millisecond)
+		}
+		clock.Sleep(time.Minute)
+
+		fmt.Println("Start to test insert")
+		var keys []string
+		deleted := make(map[string]bool)
+		publisher := make(map[string]*kademlia.Node) // which alone may delete the key
+		for i := 0; i < 3*n; i++ {
+			k := strconv.Itoa(i)
+			keys = append(keys, k)
+			publisher[k] = nodes[r.Intn(len(nodes))]
+			if err := publisher[k].O.Publish(ctx, k, []byte(k)); err != nil {
+				fmt.Println("Error: Publish", k, err)
+			}
+		}
+		check(nodes, keys, deleted)
+
+		fmt.Println("Start to test delete")
+		// a node cannot delete a key another node published
+		forged := keys[len(keys)-1]
+		if o := nodes[r.Intn(len(nodes))]; o != publisher[forged] {
+			if err := o.O.Delete(ctx, forged); err != kademlia.ErrNotOwner {
+				fmt.Println("Error: Delete of a key of another node", forged, err)
+			}
+		}
+		for _, k := range keys[:len(keys)/3] {
+			if err := publisher[k].O.Delete(ctx, k); err != nil {
+				fmt.Println("Error: Delete", k, err)
+			}
+			deleted[k] = true
+		}
+		check(nodes, keys, deleted)
+
+		fmt.Println("Start to test quit")
+		for i := 0; i < n/5; i++ {
+			p := 1 + r.Intn(len(nodes)-1)
+			_ = nodes[p].Stop()
+			nodes = append(nodes[:p], nodes[p+1:]...)
+			clock.Sleep(100 * time.Millisecond)
+		}
+		clock.Sleep(time.Minute)
+		check(nodes, keys, deleted)
+
+		// deleted keys stay deleted once their tombstones expire
+		fmt.Println("Start to test tombstone expiry")
+		clock.Sleep(4 * time.Minute)
+		check(nodes, keys, deleted)
+	})
+	fmt.Println("Simulated test finished, virtual time", clock.Now().Sub(time.Unix(0, 0)))
+}
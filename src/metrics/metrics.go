@@ -0,0 +1,323 @@
+// Package metrics keeps counters, gauges and histograms of the nodes and serves them
+// in the Prometheus text format, e.g. at /metrics
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// buckets of the histograms of the nodes
+var (
+	HopBuckets     = []float64{0, 1, 2, 3, 4, 6, 8, 12, 16, 24, 32}
+	LatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// Default is the registry the nodes use unless given another one
+var Default = NewRegistry()
+
+// Registry holds metrics, each series of a metric named by its labels
+type Registry struct {
+	lock     sync.Mutex
+	families map[string]*family
+}
+
+// a metric and its series
+type family struct {
+	help, kind string
+	series     map[string]series // by label string
+}
+
+type series interface {
+	write(w io.Writer, name, labels string)
+	// sample adds the values of the series to res, by name{labels}
+	sample(res map[string]float64, name, labels string)
+}
+
+// function NewRegistry() returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// function labelString() renders label pairs (name, value, name, value...) as name="value",...
+func labelString(labels []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(labels[i+1]))
+	}
+	return b.String()
+}
+
+// method get() returns the series of name with labels, made by create if it is new
+func (r *Registry) get(name, help, kind string, labels []string, create func() series) series {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	f, ok := r.families[name]
+	if ok == false {
+		f = &family{help: help, kind: kind, series: make(map[string]series)}
+		r.families[name] = f
+	}
+	key := labelString(labels)
+	s, ok := f.series[key]
+	if ok == false {
+		s = create()
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value which only goes up
+type Counter struct {
+	lock  sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	c.lock.Lock()
+	c.value += v
+	c.lock.Unlock()
+}
+
+func (c *Counter) write(w io.Writer, name, labels string) {
+	c.lock.Lock()
+	v := c.value
+	c.lock.Unlock()
+	fmt.Fprintf(w, "%s%s %s\n", name, braces(labels), formatFloat(v))
+}
+
+func (c *Counter) sample(res map[string]float64, name, labels string) {
+	c.lock.Lock()
+	res[name+braces(labels)] = c.value
+	c.lock.Unlock()
+}
+
+// method Counter() returns the counter name with labels (name, value pairs), made on first use
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return r.get(name, help, "counter", labels, func() series { return new(Counter) }).(*Counter)
+}
+
+// gaugeFunc is a gauge read when the metrics are written
+type gaugeFunc func() float64
+
+func (g gaugeFunc) write(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, braces(labels), formatFloat(g()))
+}
+
+func (g gaugeFunc) sample(res map[string]float64, name, labels string) {
+	res[name+braces(labels)] = g()
+}
+
+// method GaugeFunc() sets the gauge name with labels to be read from f,
+// replacing the f of the series if it exists
+func (r *Registry) GaugeFunc(name, help string, f func() float64, labels ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fam, ok := r.families[name]
+	if ok == false {
+		fam = &family{help: help, kind: "gauge", series: make(map[string]series)}
+		r.families[name] = fam
+	}
+	fam.series[labelString(labels)] = gaugeFunc(f)
+}
+
+// Histogram counts observations in buckets of upper bounds
+type Histogram struct {
+	lock    sync.Mutex
+	bounds  []float64
+	counts  []uint64 // counts[i] observations <= bounds[i] and > bounds[i-1]
+	sum     float64
+	samples uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.lock.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.samples++
+	h.lock.Unlock()
+}
+
+func (h *Histogram) write(w io.Writer, name, labels string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cum uint64
+	for i, b := range h.bounds {
+		cum += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatFloat(b), cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.samples)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(labels), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(labels), h.samples)
+}
+
+// the buckets are left out of a sample, as they are only of use to a Prometheus server
+func (h *Histogram) sample(res map[string]float64, name, labels string) {
+	h.lock.Lock()
+	res[name+"_sum"+braces(labels)] = h.sum
+	res[name+"_count"+braces(labels)] = float64(h.samples)
+	h.lock.Unlock()
+}
+
+// method Histogram() returns the histogram name with labels and buckets of upper bounds
+// in increasing order, made on first use
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return r.get(name, help, "histogram", labels, func() series {
+		return &Histogram{bounds: buckets, counts: make([]uint64, len(buckets))}
+	}).(*Histogram)
+}
+
+// method Remove() drops every series whose labels include the pairs of labels,
+// e.g. Remove("node", addr) once a node stopped
+func (r *Registry) Remove(labels ...string) {
+	pairs := labelPairs(labels)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, f := range r.families {
+		for key := range f.series {
+			if hasLabels(key, pairs) {
+				delete(f.series, key)
+			}
+		}
+	}
+}
+
+// method Snapshot() returns the values of every series whose labels include the pairs
+// of labels, by name{labels} as in the text format. A histogram gives its _sum and _count
+func (r *Registry) Snapshot(labels ...string) map[string]float64 {
+	pairs := labelPairs(labels)
+	res := make(map[string]float64)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for name, f := range r.families {
+		for key, s := range f.series {
+			if hasLabels(key, pairs) {
+				s.sample(res, name, key)
+			}
+		}
+	}
+	return res
+}
+
+// function labelPairs() renders each label pair of labels as name="value"
+func labelPairs(labels []string) []string {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labelString(labels[i:i+2]))
+	}
+	return pairs
+}
+
+// function hasLabels() returns whether the label string key holds every pair of pairs
+func hasLabels(key string, pairs []string) bool {
+	for _, p := range pairs {
+		if hasLabel(key, p) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// function hasLabel() returns whether the label string key holds the pair name="value"
+func hasLabel(key, pair string) bool {
+	for _, p := range splitLabels(key) {
+		if p == pair {
+			return true
+		}
+	}
+	return false
+}
+
+// function splitLabels() splits a label string at the commas between its pairs
+func splitLabels(key string) []string {
+	var res []string
+	start, quoted := 0, false
+	for i := 0; i < len(key); i++ {
+		switch {
+		case key[i] == '\\' && quoted:
+			i++
+		case key[i] == '"':
+			quoted = !quoted
+		case key[i] == ',' && quoted == false:
+			res = append(res, key[start:i])
+			start = i + 1
+		}
+	}
+	return append(res, key[start:])
+}
+
+// method Write() writes every metric in the Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	r.lock.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := r.families[name]
+		if len(f.series) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, f.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			f.series[key].write(bw, name, key)
+		}
+	}
+	r.lock.Unlock()
+	return bw.Flush()
+}
+
+// method Handler() returns a handler serving the metrics, e.g. at /metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.Write(w)
+	})
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
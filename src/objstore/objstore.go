@@ -0,0 +1,243 @@
+// Package objstore stores objects of any size in a DHT. An object is split into chunks
+// of ChunkSize bytes, each stored under the SHA-256 of its content, so that the chunks
+// of one object are spread over the ring and chunks shared by objects are stored once.
+// The list of chunks is kept in a JSON manifest under the key of the object:
+//
+//	obj:{key}       the manifest of the object, see Manifest
+//	chunk:{sha256}  a chunk, in hex
+//
+// Objects are uploaded and downloaded as streams, and every chunk is checked against
+// its hash on read, as is the whole object against the hash in its manifest.
+package objstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"dht"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// defaults of a Store
+const (
+	DefaultChunkSize = 256 << 10
+	DefaultParallel  = 4
+)
+
+// prefixes of the keys of manifests and chunks in the DHT
+const (
+	ManifestPrefix = "obj:"
+	ChunkPrefix    = "chunk:"
+)
+
+var ErrCorrupt = errors.New("Object corrupted ")
+
+// Manifest describes a stored object
+type Manifest struct {
+	Size      int64    `json:"size"`
+	ChunkSize int      `json:"chunk_size"`
+	Chunks    []string `json:"chunks"` // SHA-256 of each chunk in order, in hex
+	Hash      string   `json:"hash"`   // SHA-256 of the whole object, in hex
+}
+
+// Store stores objects in the DHT of node
+type Store struct {
+	ChunkSize int // bytes of a chunk, the last one of an object may be shorter
+	Parallel  int // chunks uploaded or downloaded at a time
+
+	node dht.Node
+}
+
+// function New() returns a store of objects in the DHT of node, with the default chunk size
+func New(node dht.Node) *Store {
+	return &Store{ChunkSize: DefaultChunkSize, Parallel: DefaultParallel, node: node}
+}
+
+func manifestKey(key string) string {
+	return ManifestPrefix + key
+}
+
+func chunkKey(sum string) string {
+	return ChunkPrefix + sum
+}
+
+// method Put() stores the object read from r under key until r ends, replacing the object
+// stored under key if any. The manifest is put once every chunk is, so that a reader never
+// sees part of an object. The chunks of an object which failed are left in the DHT
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) (Manifest, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunkSize := s.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	m := Manifest{ChunkSize: chunkSize}
+	whole := sha256.New()
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	failed := func() error {
+		lock.Lock()
+		defer lock.Unlock()
+		return firstErr
+	}
+
+	slots := make(chan struct{}, max(s.Parallel, 1)) // bounds the chunks held in memory
+	for {
+		buf := make([]byte, chunkSize)
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			fail(err)
+			break
+		}
+		chunk := buf[:n]
+		sum := sha256.Sum256(chunk)
+		name := hex.EncodeToString(sum[:])
+		whole.Write(chunk)
+		m.Chunks = append(m.Chunks, name)
+		m.Size += int64(n)
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if failed() != nil || ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			err := s.node.Put(ctx, chunkKey(name), chunk)
+			if err != nil {
+				fail(fmt.Errorf("put chunk %s: %w", name, err))
+			}
+		}()
+		if err == io.ErrUnexpectedEOF { // the last chunk
+			break
+		}
+	}
+	wg.Wait()
+	if err := failed(); err != nil {
+		return Manifest{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Manifest{}, err
+	}
+
+	m.Hash = hex.EncodeToString(whole.Sum(nil))
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return Manifest{}, err
+	}
+	err = s.node.Put(ctx, manifestKey(key), raw)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return m, nil
+}
+
+// method Stat() returns the manifest of the object stored under key,
+// dht.ErrNotFound if there is none
+func (s *Store) Stat(ctx context.Context, key string) (Manifest, error) {
+	raw, err := s.node.Get(ctx, manifestKey(key))
+	if err != nil {
+		return Manifest{}, err
+	}
+	var m Manifest
+	err = json.Unmarshal(raw, &m)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%wmanifest of %s: %v", ErrCorrupt, key, err)
+	}
+	return m, nil
+}
+
+// method Get() writes the object stored under key to w, see Read()
+func (s *Store) Get(ctx context.Context, key string, w io.Writer) (Manifest, error) {
+	m, err := s.Stat(ctx, key)
+	if err != nil {
+		return m, err
+	}
+	return m, s.Read(ctx, m, w)
+}
+
+// method Read() writes the object of manifest m to w, fetching up to Parallel chunks ahead.
+// It returns ErrCorrupt once a chunk does not match its hash, or the object does not match
+// its manifest, in which case w has received part of the object only
+func (s *Store) Read(ctx context.Context, m Manifest, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		chunk []byte
+		err   error
+	}
+	results := make([]chan result, len(m.Chunks))
+	fetch := func(i int) {
+		results[i] = make(chan result, 1)
+		go func() {
+			chunk, err := s.getChunk(ctx, m.Chunks[i])
+			results[i] <- result{chunk, err}
+		}()
+	}
+	for i := 0; i < len(m.Chunks) && i < max(s.Parallel, 1); i++ {
+		fetch(i)
+	}
+
+	whole := sha256.New()
+	var size int64
+	for i := range m.Chunks {
+		res := <-results[i]
+		if res.err != nil {
+			return res.err
+		}
+		if next := i + max(s.Parallel, 1); next < len(m.Chunks) {
+			fetch(next)
+		}
+		whole.Write(res.chunk)
+		size += int64(len(res.chunk))
+		_, err := w.Write(res.chunk)
+		if err != nil {
+			return err
+		}
+	}
+	if size != m.Size || hex.EncodeToString(whole.Sum(nil)) != m.Hash {
+		return fmt.Errorf("%wobject of hash %s does not match its manifest", ErrCorrupt, m.Hash)
+	}
+	return nil
+}
+
+// method getChunk() returns the chunk of hash sum, checked against it
+func (s *Store) getChunk(ctx context.Context, sum string) ([]byte, error) {
+	chunk, err := s.node.Get(ctx, chunkKey(sum))
+	if err != nil {
+		return nil, fmt.Errorf("get chunk %s: %w", sum, err)
+	}
+	got := sha256.Sum256(chunk)
+	if hex.EncodeToString(got[:]) != sum {
+		return nil, fmt.Errorf("%wchunk %s does not match its hash", ErrCorrupt, sum)
+	}
+	return chunk, nil
+}
+
+// method Delete() deletes the object stored under key, dht.ErrNotFound if there is none.
+// Only its manifest is deleted: its chunks may be shared with other objects, and are left
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return s.node.Del(ctx, manifestKey(key))
+}
@@ -0,0 +1,117 @@
+// the Redis serialization protocol (RESP 2): commands in, replies out
+
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// bounds of a command, beyond which the connection is dropped
+const (
+	MaxBulkLen = 16 << 20 // bytes of an argument
+	MaxArgs    = 1 << 16  // arguments of a command
+	maxInline  = 64 << 10 // bytes of an inline command, or of any line
+)
+
+var ErrProtocol = errors.New("Protocol error ")
+
+// function readCommand() reads a command, an array of bulk strings as clients send,
+// or an inline line of words as typed in telnet. An empty inline line is an empty command
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > MaxArgs {
+		return nil, fmt.Errorf("%winvalid multibulk length", ErrProtocol)
+	}
+	args := make([]string, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%wexpected '$', got '%.1s'", ErrProtocol, line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > MaxBulkLen {
+			return nil, fmt.Errorf("%winvalid bulk length", ErrProtocol)
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%wbulk string not ended by CRLF", ErrProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// function readLine() reads a line ended by CRLF, or by LF alone as inline commands may be.
+// A line longer than maxInline is a protocol error, read no further than the limit
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxInline+2 { // CRLF aside
+			return "", fmt.Errorf("%wtoo big request line", ErrProtocol)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		return strings.TrimSuffix(string(line[:len(line)-1]), "\r"), nil
+	}
+}
+
+// writer writes replies, which are sent once flushed
+type writer struct {
+	*bufio.Writer
+}
+
+// method simple() writes a status reply, e.g. OK
+func (w writer) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+// method error() writes an error reply. msg starts with its kind, e.g. "ERR unknown command"
+func (w writer) error(msg string) {
+	w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(msg) + "\r\n")
+}
+
+func (w writer) integer(n int) {
+	w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w writer) bulk(s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+// method null() writes the nil bulk string, e.g. the value of a missing key
+func (w writer) null() {
+	w.WriteString("$-1\r\n")
+}
+
+// method array() writes the header of an array of n replies, which follow it
+func (w writer) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
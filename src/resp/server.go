@@ -0,0 +1,373 @@
+// Package resp serves a node to Redis clients over RESP, the Redis serialization protocol.
+// GET, SET, DEL, EXISTS, MGET, MSET and EXPIRE map onto the Get, Put and Del of the node,
+// which route each key to its owner. The commands of many keys run as one batch, see dht.MultiGet.
+// EXPIRE and the EX and PX options of SET are only served for a dht.Expirer.
+// Other commands are answered with an error.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"dht"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server serves a node to Redis clients
+type Server struct {
+	node    dht.Node
+	timeout time.Duration
+	log     *slog.Logger
+
+	lock     sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+// function New() returns a server of node, whose commands give up after timeout
+func New(node dht.Node, timeout time.Duration) *Server {
+	return &Server{node: node, timeout: timeout, conns: make(map[net.Conn]bool),
+		log: slog.Default().With("node", node.GetAddr(), "op", "resp")}
+}
+
+// method ListenAndServe() serves the clients which connect to addr until Close() is called
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// method Serve() serves the clients accepted by l until Close() is called
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.lock.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.lock.Lock()
+		s.conns[conn] = true
+		s.lock.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// method Close() stops accepting clients and drops the connected ones
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// method serveConn() answers the commands of a client in order until it quits
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	w := writer{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				w.error("ERR " + err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := s.exec(w, args)
+		// a pipelined client gets its replies once it sent every command it had
+		if r.Buffered() == 0 || quit {
+			if w.Flush() != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// a command: its handler and the arguments it takes after its name,
+// at least min and at most max (-1 for any), in steps of step
+type command struct {
+	run            func(s *Server, w writer, args []string)
+	min, max, step int
+}
+
+var commands = map[string]command{
+	"get":    {(*Server).get, 1, 1, 1},
+	"set":    {(*Server).set, 2, -1, 1},
+	"del":    {(*Server).del, 1, -1, 1},
+	"exists": {(*Server).exists, 1, -1, 1},
+	"mget":   {(*Server).mget, 1, -1, 1},
+	"mset":   {(*Server).mset, 2, -1, 2},
+	"expire": {(*Server).expire, 2, 2, 1},
+	"ping":   {(*Server).ping, 0, 1, 1},
+	"echo":   {(*Server).echo, 1, 1, 1},
+}
+
+// method exec() runs a command and writes its reply, and returns whether the client quits
+func (s *Server) exec(w writer, args []string) bool {
+	name := strings.ToLower(args[0])
+	if name == "quit" {
+		w.simple("OK")
+		return true
+	}
+	cmd, ok := commands[name]
+	if ok == false {
+		w.error("ERR unknown command '" + args[0] + "'")
+		return false
+	}
+	n := len(args) - 1
+	if n < cmd.min || (cmd.max >= 0 && n > cmd.max) || (n-cmd.min)%cmd.step != 0 {
+		w.error("ERR wrong number of arguments for '" + name + "' command")
+		return false
+	}
+	cmd.run(s, w, args[1:])
+	return false
+}
+
+// method context() returns the context of a command, which gives up after the timeout
+func (s *Server) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeout)
+}
+
+// method fail() writes the error of an operation of the node
+func (s *Server) fail(w writer, err error) {
+	if errors.Is(err, dht.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		w.error("TIMEOUT " + err.Error())
+		return
+	}
+	s.log.Debug("command failed", "err", err)
+	w.error("ERR " + err.Error())
+}
+
+func (s *Server) get(w writer, args []string) {
+	ctx, cancel := s.context()
+	defer cancel()
+	value, err := s.node.Get(ctx, args[0])
+	switch {
+	case errors.Is(err, dht.ErrNotFound):
+		w.null()
+	case err != nil:
+		s.fail(w, err)
+	default:
+		w.bulk(string(value))
+	}
+}
+
+// method set() serves SET key value [EX seconds|PX milliseconds] [NX]
+func (s *Server) set(w writer, args []string) {
+	var ttl time.Duration
+	nx := false
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "nx":
+			nx = true
+		case (opt == "ex" || opt == "px") && i+1 < len(args) && ttl == 0:
+			unit := time.Second
+			if opt == "px" {
+				unit = time.Millisecond
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 || n > math.MaxInt64/int64(unit) { // longer than a time.Duration holds
+				w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+	if nx && ttl > 0 {
+		w.error("ERR NX with an expire time is not supported")
+		return
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+	var err error
+	switch {
+	case nx:
+		c, ok := s.node.(dht.CASNode)
+		if ok == false {
+			w.error("ERR NX is not supported by the protocol of the node")
+			return
+		}
+		err = c.PutIfAbsent(ctx, args[0], []byte(args[1]))
+		if errors.Is(err, dht.ErrConditionFailed) {
+			w.null()
+			return
+		}
+	case ttl > 0:
+		e, ok := s.node.(dht.Expirer)
+		if ok == false {
+			w.error("ERR expire times are not supported by the protocol of the node")
+			return
+		}
+		err = e.PutTTL(ctx, args[0], []byte(args[1]), ttl)
+	default:
+		err = s.node.Put(ctx, args[0], []byte(args[1]))
+	}
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	w.simple("OK")
+}
+
+// function found() returns the number of keys of a batch which were found,
+// or the first error other than dht.ErrNotFound
+func found(res []dht.Result) (int, error) {
+	n := 0
+	for _, r := range res {
+		if errors.Is(r.Err, dht.ErrNotFound) {
+			continue
+		}
+		if r.Err != nil {
+			return 0, r.Err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// method del() replies the number of keys deleted, the missing ones not counted
+func (s *Server) del(w writer, args []string) {
+	ctx, cancel := s.context()
+	defer cancel()
+	deleted, err := found(dht.MultiDelete(ctx, s.node, args))
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	w.integer(deleted)
+}
+
+// method exists() replies the number of keys which exist, a key given twice counted twice
+func (s *Server) exists(w writer, args []string) {
+	ctx, cancel := s.context()
+	defer cancel()
+	n, err := found(dht.MultiGet(ctx, s.node, args))
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	w.integer(n)
+}
+
+func (s *Server) mget(w writer, args []string) {
+	ctx, cancel := s.context()
+	defer cancel()
+	res := dht.MultiGet(ctx, s.node, args)
+	if _, err := found(res); err != nil {
+		s.fail(w, err)
+		return
+	}
+	w.array(len(res))
+	for _, r := range res {
+		if r.Err != nil {
+			w.null()
+		} else {
+			w.bulk(string(r.Value))
+		}
+	}
+}
+
+// method mset() puts the pairs as one batch. The pairs put are kept if another one fails
+func (s *Server) mset(w writer, args []string) {
+	ctx, cancel := s.context()
+	defer cancel()
+	pairs := make([]dht.Pair, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		pairs = append(pairs, dht.Pair{Key: args[i], Value: []byte(args[i+1])})
+	}
+	for _, r := range dht.MultiPut(ctx, s.node, pairs) {
+		if r.Err != nil {
+			s.fail(w, r.Err)
+			return
+		}
+	}
+	w.simple("OK")
+}
+
+// method expire() replies 1 if the key was given the time to live, 0 if it does not exist
+func (s *Server) expire(w writer, args []string) {
+	e, ok := s.node.(dht.Expirer)
+	if ok == false {
+		w.error("ERR expire times are not supported by the protocol of the node")
+		return
+	}
+	seconds, err := strconv.Atoi(args[1])
+	if err != nil {
+		w.error("ERR value is not an integer or out of range")
+		return
+	}
+	if int64(seconds) > math.MaxInt64/int64(time.Second) { // longer than a time.Duration holds
+		w.error("ERR invalid expire time in 'expire' command")
+		return
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	if seconds <= 0 { // expired already
+		err = s.node.Del(ctx, args[0])
+	} else {
+		err = e.Expire(ctx, args[0], time.Duration(seconds)*time.Second)
+	}
+	if errors.Is(err, dht.ErrNotFound) {
+		w.integer(0)
+		return
+	}
+	if err != nil {
+		s.fail(w, err)
+		return
+	}
+	w.integer(1)
+}
+
+func (s *Server) ping(w writer, args []string) {
+	if len(args) == 1 {
+		w.bulk(args[0])
+		return
+	}
+	w.simple("PONG")
+}
+
+func (s *Server) echo(w writer, args []string) {
+	w.bulk(args[0])
+}
@@ -0,0 +1,325 @@
+// Package rpcpool keeps persistent net/rpc clients to the peers of a node.
+// A client multiplexes every call to a peer over one TCP connection, and the health of a peer
+// is judged from the results of its calls, so that a ping usually costs no network round trip.
+package rpcpool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrPeerDown    = errors.New("Peer down ")
+	ErrCallTimeout = errors.New("Call timed out ")
+)
+
+// Options tune a Pool, a zero field meaning the default
+type Options struct {
+	DialTimeout   time.Duration // of a new connection
+	CallTimeout   time.Duration // of a call, which fails past it, the connection being dropped at FailThreshold
+	IdleTimeout   time.Duration // unused connections are closed after it
+	FreshWindow   time.Duration // a peer which answered within it is alive without a ping
+	FailThreshold int           // consecutive failed calls which mark a peer down
+	Cooldown      time.Duration // calls to a peer marked down fail at once for it
+}
+
+// function DefaultOptions() returns the options of a Pool unless told otherwise
+func DefaultOptions() Options {
+	return Options{
+		DialTimeout:   time.Second,
+		CallTimeout:   10 * time.Second,
+		IdleTimeout:   time.Minute,
+		FreshWindow:   500 * time.Millisecond,
+		FailThreshold: 3,
+		Cooldown:      2 * time.Second,
+	}
+}
+
+func (o Options) withDefaults() Options {
+	def := DefaultOptions()
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = def.DialTimeout
+	}
+	if o.CallTimeout <= 0 {
+		o.CallTimeout = def.CallTimeout
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = def.IdleTimeout
+	}
+	if o.FreshWindow <= 0 {
+		o.FreshWindow = def.FreshWindow
+	}
+	if o.FailThreshold <= 0 {
+		o.FailThreshold = def.FailThreshold
+	}
+	if o.Cooldown <= 0 {
+		o.Cooldown = def.Cooldown
+	}
+	return o
+}
+
+// Pool holds a client per peer
+type Pool struct {
+	opts Options
+
+	lock      sync.Mutex
+	peers     map[string]*peer
+	lastSweep time.Time
+}
+
+// state of the connection to a peer
+type peer struct {
+	lock      sync.Mutex // also held while dialing, so that callers share one dial
+	client    *rpc.Client
+	conn      *trackedConn
+	lastUsed  time.Time
+	lastOK    time.Time
+	failures  int // consecutive failed calls
+	downUntil time.Time
+}
+
+// trackedConn notes when its peer closed it or it broke, which the reader of the
+// rpc.Client sees at once
+type trackedConn struct {
+	net.Conn
+	broken int32
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		atomic.StoreInt32(&c.broken, 1)
+	}
+	return n, err
+}
+
+func (c *trackedConn) isBroken() bool {
+	return atomic.LoadInt32(&c.broken) == 1
+}
+
+// function New() returns an empty pool
+func New(opts Options) *Pool {
+	return &Pool{opts: opts.withDefaults(), peers: make(map[string]*peer)}
+}
+
+// method peer() returns the state of addr, made on first use
+func (p *Pool) peer(addr string) *peer {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pr, ok := p.peers[addr]
+	if ok == false {
+		pr = new(peer)
+		p.peers[addr] = pr
+	}
+	return pr
+}
+
+// method drop() closes the client of a peer, the lock of the peer being held
+func (pr *peer) drop() {
+	if pr.client != nil {
+		_ = pr.client.Close()
+		pr.client, pr.conn = nil, nil
+	}
+}
+
+// method fail() counts a failed call, the lock of the peer being held
+func (pr *peer) fail(now time.Time, opts Options) {
+	pr.drop()
+	pr.failures++
+	if pr.failures >= opts.FailThreshold {
+		pr.downUntil = now.Add(opts.Cooldown)
+	}
+}
+
+// method client() returns the client of addr, dialing it if it has none
+func (p *Pool) client(addr string) (*peer, *rpc.Client, error) {
+	pr := p.peer(addr)
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	now := time.Now()
+	pr.lastUsed = now
+	if pr.client != nil && pr.conn.isBroken() {
+		pr.fail(now, p.opts)
+	}
+	if pr.client != nil {
+		return pr, pr.client, nil
+	}
+	if now.Before(pr.downUntil) {
+		return pr, nil, ErrPeerDown
+	}
+	conn, err := net.DialTimeout("tcp", addr, p.opts.DialTimeout)
+	if err != nil {
+		pr.fail(now, p.opts)
+		return pr, nil, err
+	}
+	pr.conn = &trackedConn{Conn: conn}
+	pr.client = rpc.NewClient(pr.conn)
+	return pr, pr.client, nil
+}
+
+// method result() updates the health of a peer after a call on client returned err.
+// An error returned by the method itself leaves the connection healthy, and a call which timed
+// out leaves it open to the other calls to the peer until FailThreshold calls in a row failed
+func (p *Pool) result(pr *peer, client *rpc.Client, err error) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	now := time.Now()
+	if _, ok := err.(rpc.ServerError); err == nil || ok {
+		pr.lastOK = now
+		pr.failures = 0
+		pr.downUntil = time.Time{}
+		return
+	}
+	if pr.client != client {
+		return
+	}
+	if err == ErrCallTimeout && pr.failures+1 < p.opts.FailThreshold {
+		pr.failures++
+		return
+	}
+	pr.fail(now, p.opts)
+}
+
+// method Call() invokes method on the node at addr over the connection to it
+func (p *Pool) Call(addr, method string, args, reply interface{}) error {
+	return p.CallTimeout(addr, method, args, reply, p.opts.CallTimeout)
+}
+
+// method CallTimeout() is Call() giving up after timeout instead of CallTimeout
+func (p *Pool) CallTimeout(addr, method string, args, reply interface{}, timeout time.Duration) error {
+	return p.call(context.Background(), addr, method, args, reply, timeout)
+}
+
+// method CallContext() is Call() giving up once ctx ends, if before CallTimeout.
+// A call given up on for ctx says nothing of the peer, whose health it leaves alone
+func (p *Pool) CallContext(ctx context.Context, addr, method string, args, reply interface{}) error {
+	return p.call(ctx, addr, method, args, reply, p.opts.CallTimeout)
+}
+
+// method call() invokes method on the node at addr until ctx ends or timeout passes.
+// The reply is decoded into a value of its own, copied to reply once the call is done,
+// so that a call given up on which answers later does not write to reply
+func (p *Pool) call(ctx context.Context, addr, method string, args, reply interface{}, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.sweep()
+	pr, client, err := p.client(addr)
+	if err != nil {
+		return err
+	}
+	private := reply
+	if v := reflect.ValueOf(reply); v.Kind() == reflect.Ptr && v.IsNil() == false {
+		private = reflect.New(v.Type().Elem()).Interface()
+	}
+	call := client.Go(method, args, private, make(chan *rpc.Call, 1))
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-call.Done:
+		err = call.Error
+		if err == nil && private != reply {
+			reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(private).Elem())
+		}
+	case <-timer.C:
+		err = ErrCallTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	p.result(pr, client, err)
+	return err
+}
+
+// method Ping() checks whether the node at addr is reachable: a peer which answered
+// within FreshWindow is, a peer marked down is not, otherwise it is asked over its connection
+func (p *Pool) Ping(addr string) bool {
+	pr := p.peer(addr)
+	pr.lock.Lock()
+	now := time.Now()
+	fresh := pr.client != nil && pr.conn.isBroken() == false && now.Sub(pr.lastOK) < p.opts.FreshWindow
+	down := pr.client == nil && now.Before(pr.downUntil)
+	pr.lock.Unlock()
+	if fresh {
+		return true
+	}
+	if down {
+		return false
+	}
+	return p.Call(addr, "Health.Ping", 0, new(bool)) == nil
+}
+
+// method sweep() closes the connections unused for IdleTimeout, at most every IdleTimeout/2
+func (p *Pool) sweep() {
+	now := time.Now()
+	p.lock.Lock()
+	if now.Sub(p.lastSweep) < p.opts.IdleTimeout/2 {
+		p.lock.Unlock()
+		return
+	}
+	p.lastSweep = now
+	peers := make(map[string]*peer, len(p.peers))
+	for addr, pr := range p.peers {
+		peers[addr] = pr
+	}
+	p.lock.Unlock()
+
+	for addr, pr := range peers {
+		pr.lock.Lock()
+		idle := now.Sub(pr.lastUsed) >= p.opts.IdleTimeout
+		if idle {
+			pr.drop()
+		}
+		forget := idle && now.After(pr.downUntil)
+		pr.lock.Unlock()
+		if forget {
+			p.lock.Lock()
+			if p.peers[addr] == pr {
+				delete(p.peers, addr)
+			}
+			p.lock.Unlock()
+		}
+	}
+}
+
+// method Conns() returns the number of open connections
+func (p *Pool) Conns() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	n := 0
+	for _, pr := range p.peers {
+		pr.lock.Lock()
+		if pr.client != nil {
+			n++
+		}
+		pr.lock.Unlock()
+	}
+	return n
+}
+
+// method Close() closes every connection, a later call dials again
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	peers := p.peers
+	p.peers = make(map[string]*peer)
+	p.lock.Unlock()
+	for _, pr := range peers {
+		pr.lock.Lock()
+		pr.drop()
+		pr.lock.Unlock()
+	}
+	return nil
+}
+
+// Health answers the pings of the pools of other nodes, see Listen()
+type Health struct{}
+
+func (Health) Ping(_ int, res *bool) error {
+	*res = true
+	return nil
+}
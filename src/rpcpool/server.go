@@ -0,0 +1,72 @@
+package rpcpool
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+// Listener serves a net/rpc server and, as the connections of pools outlive single calls,
+// closes the connections it accepted when it is closed
+type Listener struct {
+	listener net.Listener
+	server   *rpc.Server
+
+	lock   sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// function Listen() makes server answer pings and serves it at addr, e.g. ":2000"
+func Listen(server *rpc.Server, addr string) (*Listener, error) {
+	err := server.RegisterName("Health", Health{})
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{listener: listener, server: server, conns: make(map[net.Conn]struct{})}
+	go l.accept()
+	return l, nil
+}
+
+func (l *Listener) accept() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		l.lock.Lock()
+		if l.closed {
+			l.lock.Unlock()
+			_ = conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.lock.Unlock()
+		go func() {
+			l.server.ServeConn(conn)
+			l.lock.Lock()
+			delete(l.conns, conn)
+			l.lock.Unlock()
+		}()
+	}
+}
+
+// method Addr() returns the address the listener accepts at
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// method Close() stops accepting and closes every accepted connection
+func (l *Listener) Close() error {
+	l.lock.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.lock.Unlock()
+	return l.listener.Close()
+}
@@ -0,0 +1,189 @@
+// virtual clock of the simulated network
+
+package simnet
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Clock is a virtual clock. Goroutines started by Go run one at a time:
+// the clock only moves on when every one of them is blocked in Sleep,
+// and timers due at the same instant fire in the order they were set,
+// so a run only depends on the seed of the network.
+// Network calls take virtual time without giving up the turn, so a node
+// never yields while it holds one of its own locks: the time is charged to
+// the running goroutine and is added to its next Sleep.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	seq     uint64
+	timers  timerQueue
+	running int
+	debt    time.Duration // time spent in calls by the running goroutine
+
+	// Stall is the real time the clock waits for a running goroutine before
+	// moving on anyway, which breaks determinism; zero means wait forever
+	Stall time.Duration
+}
+
+type timer struct {
+	when time.Time
+	seq  uint64
+	wake chan struct{}
+}
+
+type timerQueue []*timer
+
+func (q timerQueue) Len() int { return len(q) }
+func (q timerQueue) Less(i, j int) bool {
+	if q[i].when.Equal(q[j].when) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].when.Before(q[j].when)
+}
+func (q timerQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *timerQueue) Push(x interface{}) { *q = append(*q, x.(*timer)) }
+func (q *timerQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return t
+}
+
+// function NewClock() returns a virtual clock starting at start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// method Now() returns the virtual time seen by the running goroutine
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now.Add(c.debt)
+}
+
+// method Sleep() blocks the calling goroutine for d of virtual time
+func (c *Clock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	wake := c.schedule(d + c.debt)
+	c.debt = 0
+	c.running--
+	c.mu.Unlock()
+	<-wake
+}
+
+// charge d of virtual time to the running goroutine without yielding
+func (c *Clock) spend(d time.Duration) {
+	c.mu.Lock()
+	c.debt += d
+	c.mu.Unlock()
+}
+
+// method Go() starts f in a goroutine scheduled by the clock
+func (c *Clock) Go(f func()) {
+	c.mu.Lock()
+	wake := c.schedule(0)
+	c.mu.Unlock()
+	go func() {
+		<-wake
+		defer c.exit()
+		f()
+	}()
+}
+
+// method Advance() lets d of virtual time pass, firing every timer due in it
+func (c *Clock) Advance(d time.Duration) {
+	limit := c.Now().Add(d)
+	for {
+		c.waitIdle()
+		if !c.fire(limit) {
+			break
+		}
+	}
+	c.mu.Lock()
+	if limit.After(c.now) {
+		c.now = limit
+	}
+	c.mu.Unlock()
+}
+
+// method Run() runs f under the clock and lets virtual time pass until f returns
+func (c *Clock) Run(f func()) {
+	done := make(chan struct{})
+	c.Go(func() {
+		defer close(done)
+		f()
+	})
+	for {
+		c.waitIdle()
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if !c.fire(time.Time{}) {
+			// nothing is scheduled: f is blocked outside the clock
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+}
+
+// schedule a wake-up d from now, c.mu must be held
+func (c *Clock) schedule(d time.Duration) chan struct{} {
+	if d < 0 {
+		d = 0
+	}
+	t := &timer{when: c.now.Add(d), seq: c.seq, wake: make(chan struct{})}
+	c.seq++
+	heap.Push(&c.timers, t)
+	return t.wake
+}
+
+// fire the earliest timer not after limit (no limit if zero), returns false if there is none
+func (c *Clock) fire(limit time.Time) bool {
+	c.mu.Lock()
+	if len(c.timers) == 0 || (!limit.IsZero() && c.timers[0].when.After(limit)) {
+		c.mu.Unlock()
+		return false
+	}
+	t := heap.Pop(&c.timers).(*timer)
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	c.running++
+	c.mu.Unlock()
+	close(t.wake)
+	return true
+}
+
+func (c *Clock) exit() {
+	c.mu.Lock()
+	c.debt = 0
+	c.running--
+	c.mu.Unlock()
+}
+
+// wait until no goroutine of the clock is running, or until Stall has passed
+func (c *Clock) waitIdle() {
+	start := time.Now()
+	for i := 0; ; i++ {
+		c.mu.Lock()
+		idle := c.running <= 0
+		c.mu.Unlock()
+		if idle || (c.Stall > 0 && time.Since(start) > c.Stall) {
+			return
+		}
+		if i < 100 {
+			runtime.Gosched()
+		} else {
+			time.Sleep(20 * time.Microsecond)
+		}
+	}
+}
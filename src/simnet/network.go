@@ -0,0 +1,227 @@
+// simulated network carrying calls between in-process nodes
+
+package simnet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math/rand"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTimeout = errors.New("simnet: call timed out ")
+	ErrRefused = errors.New("simnet: connection refused ")
+)
+
+// Network connects Endpoints in one process.
+// Every message is delayed by a random latency and may be lost,
+// both drawn from a generator seeded by New.
+type Network struct {
+	clock *Clock
+
+	mu         sync.Mutex
+	rand       *rand.Rand
+	endpoints  map[string]*Endpoint
+	minLatency time.Duration
+	maxLatency time.Duration
+	lossRate   float64
+	timeout    time.Duration
+
+	// one gob stream for all copies, so that type information is sent once
+	codecMu sync.Mutex
+	buf     bytes.Buffer
+	enc     *gob.Encoder
+	dec     *gob.Decoder
+}
+
+// function New() returns a network whose latency and loss are drawn from seed
+func New(seed int64) *Network {
+	return &Network{
+		clock:      NewClock(time.Unix(0, 0)),
+		rand:       rand.New(rand.NewSource(seed)),
+		endpoints:  make(map[string]*Endpoint),
+		minLatency: time.Millisecond,
+		maxLatency: 5 * time.Millisecond,
+		timeout:    500 * time.Millisecond,
+	}
+}
+
+// method Clock() returns the virtual clock of the network
+func (n *Network) Clock() *Clock {
+	return n.clock
+}
+
+// method SetLatency() sets the range of one-way message latency
+func (n *Network) SetLatency(min, max time.Duration) {
+	n.mu.Lock()
+	n.minLatency, n.maxLatency = min, max
+	n.mu.Unlock()
+}
+
+// method SetLoss() sets the probability that a message is lost,
+// and how long the sender waits before it gives up
+func (n *Network) SetLoss(rate float64, timeout time.Duration) {
+	n.mu.Lock()
+	n.lossRate, n.timeout = rate, timeout
+	n.mu.Unlock()
+}
+
+// method Endpoint() returns a transport for the node at addr
+func (n *Network) Endpoint(addr string) *Endpoint {
+	return &Endpoint{net: n, addr: addr, services: make(map[string]reflect.Value)}
+}
+
+// deliver one message: wait for its latency and report whether it arrived
+func (n *Network) deliver() bool {
+	n.mu.Lock()
+	lost := n.lossRate > 0 && n.rand.Float64() < n.lossRate
+	delay := n.minLatency
+	if lost {
+		delay = n.timeout
+	} else if n.maxLatency > n.minLatency {
+		delay += time.Duration(n.rand.Int63n(int64(n.maxLatency - n.minLatency)))
+	}
+	n.mu.Unlock()
+	n.clock.spend(delay)
+	return !lost
+}
+
+func (n *Network) lookup(addr string) *Endpoint {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.endpoints[addr]
+}
+
+// Endpoint is the address of one node on a Network.
+// It implements the Transport interfaces of chord and kademlia.
+type Endpoint struct {
+	net      *Network
+	addr     string
+	services map[string]reflect.Value
+}
+
+// method Serve() makes the exported methods of rcvr callable as "Type.Method"
+func (e *Endpoint) Serve(rcvr interface{}) error {
+	name := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	if name == "" {
+		return errors.New("simnet: receiver has no type name ")
+	}
+	return e.ServeName(name, rcvr)
+}
+
+// method ServeName() makes the exported methods of rcvr callable as "name.Method"
+func (e *Endpoint) ServeName(name string, rcvr interface{}) error {
+	v := reflect.ValueOf(rcvr)
+	e.net.mu.Lock()
+	defer e.net.mu.Unlock()
+	if other, ok := e.net.endpoints[e.addr]; ok && other != e {
+		return errors.New("simnet: address already in use: " + e.addr + " ")
+	}
+	e.services[name] = v
+	e.net.endpoints[e.addr] = e
+	return nil
+}
+
+// method Close() takes the endpoint off the network
+func (e *Endpoint) Close() error {
+	e.net.mu.Lock()
+	if e.net.endpoints[e.addr] == e {
+		delete(e.net.endpoints, e.addr)
+	}
+	e.net.mu.Unlock()
+	return nil
+}
+
+func (e *Endpoint) Ping(addr string) bool {
+	if !e.net.deliver() {
+		return false
+	}
+	ok := e.net.lookup(addr) != nil
+	return e.net.deliver() && ok
+}
+
+// method Call() invokes method on the endpoint at addr.
+// args and reply are copied through gob, as net/rpc would do.
+func (e *Endpoint) Call(addr, method string, args, reply interface{}) error {
+	if !e.net.deliver() {
+		return ErrTimeout
+	}
+	target := e.net.lookup(addr)
+	if target == nil {
+		return ErrRefused
+	}
+
+	dot := strings.LastIndex(method, ".")
+	if dot < 0 {
+		return errors.New("simnet: service/method request ill-formed: " + method + " ")
+	}
+	e.net.mu.Lock()
+	service, ok := target.services[method[:dot]]
+	e.net.mu.Unlock()
+	if !ok {
+		return errors.New("simnet: can't find service " + method + " ")
+	}
+	m := service.MethodByName(method[dot+1:])
+	if !m.IsValid() {
+		return errors.New("simnet: can't find method " + method + " ")
+	}
+
+	argType := m.Type().In(0)
+	var argv reflect.Value
+	if argType.Kind() == reflect.Ptr {
+		argv = reflect.New(argType.Elem())
+	} else {
+		argv = reflect.New(argType)
+	}
+	err := e.net.copyValue(argv, args)
+	if err != nil {
+		return err
+	}
+	if argType.Kind() != reflect.Ptr {
+		argv = argv.Elem()
+	}
+	// like net/rpc, hand the method an empty map or slice rather than nil
+	replyv := reflect.New(m.Type().In(1).Elem())
+	switch replyv.Elem().Kind() {
+	case reflect.Map:
+		replyv.Elem().Set(reflect.MakeMap(replyv.Elem().Type()))
+	case reflect.Slice:
+		replyv.Elem().Set(reflect.MakeSlice(replyv.Elem().Type(), 0, 0))
+	}
+
+	out := m.Call([]reflect.Value{argv, replyv})
+
+	if !e.net.deliver() {
+		return ErrTimeout
+	}
+	if errv := out[0].Interface(); errv != nil {
+		return rpc.ServerError(errv.(error).Error())
+	}
+	return e.net.copyValue(reflect.ValueOf(reply), replyv.Interface())
+}
+
+// copy src into the value dst points to through a gob round trip
+func (n *Network) copyValue(dst reflect.Value, src interface{}) error {
+	n.codecMu.Lock()
+	defer n.codecMu.Unlock()
+	if n.enc == nil {
+		n.buf.Reset()
+		n.enc = gob.NewEncoder(&n.buf)
+		n.dec = gob.NewDecoder(&n.buf)
+	}
+	err := n.enc.Encode(src)
+	if err == nil {
+		err = n.dec.DecodeValue(dst)
+	}
+	if err != nil {
+		// the stream may be broken halfway, start a new one
+		n.enc, n.dec = nil, nil
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package simnet
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Counter is served by the nodes of the tests
+type Counter struct {
+	calls int
+}
+
+func (c *Counter) Add(n int, res *int) error {
+	c.calls += n
+	*res = c.calls
+	return nil
+}
+
+// function churn() runs n nodes calling each other on a lossy network seeded with seed,
+// closing and reopening nodes as it goes, and returns what each call saw, in order
+func churn(seed int64, n int) []string {
+	network := New(seed)
+	network.SetLoss(0.1, 200*time.Millisecond)
+	clock := network.Clock()
+	r := rand.New(rand.NewSource(seed))
+
+	addrOf := func(i int) string {
+		return fmt.Sprintf("10.0.0.%d:2000", i)
+	}
+	endpoints := make([]*Endpoint, n)
+	open := func(i int) {
+		endpoints[i] = network.Endpoint(addrOf(i))
+		_ = endpoints[i].Serve(new(Counter))
+	}
+	var trace []string
+	clock.Run(func() {
+		for i := range endpoints {
+			open(i)
+		}
+		done := 0
+		for i := range endpoints {
+			e := endpoints[i]
+			wait := time.Duration(r.Intn(100)) * time.Millisecond
+			clock.Go(func() {
+				for j := 0; j < 20; j++ {
+					clock.Sleep(wait)
+					to := addrOf((i + j) % n)
+					var res int
+					err := e.Call(to, "Counter.Add", j, &res)
+					trace = append(trace, fmt.Sprintf("%v %s->%s %d %v", clock.Now().UnixNano(), e.addr, to, res, err))
+				}
+				done++
+			})
+		}
+		for j := 0; done < n; j++ {
+			clock.Sleep(50 * time.Millisecond)
+			i := r.Intn(n)
+			if j%2 == 0 {
+				_ = endpoints[i].Close()
+			} else {
+				open(i)
+			}
+		}
+	})
+	return trace
+}
+
+func TestClockOrder(t *testing.T) {
+	clock := NewClock(time.Unix(0, 0))
+	var order []int
+	clock.Run(func() {
+		done := 0
+		for i, d := range []int{30, 10, 20, 10} {
+			clock.Go(func() {
+				clock.Sleep(time.Duration(d) * time.Millisecond)
+				order = append(order, i)
+				done++
+			})
+		}
+		for done < 4 {
+			clock.Sleep(time.Millisecond)
+		}
+	})
+	// timers due at the same instant fire in the order they were set
+	if want := []int{1, 3, 2, 0}; reflect.DeepEqual(order, want) == false {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	if got := clock.Now().Sub(time.Unix(0, 0)); got != 30*time.Millisecond {
+		t.Fatalf("virtual time = %v, want 30ms", got)
+	}
+}
+
+func TestChurnDeterministic(t *testing.T) {
+	first := churn(7, 8)
+	if len(first) != 8*20 {
+		t.Fatalf("%d calls, want %d", len(first), 8*20)
+	}
+	if second := churn(7, 8); reflect.DeepEqual(first, second) == false {
+		t.Fatal("two runs with the same seed differ")
+	}
+	if other := churn(8, 8); reflect.DeepEqual(first, other) {
+		t.Fatal("runs with different seeds are the same")
+	}
+}
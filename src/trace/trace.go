@@ -0,0 +1,124 @@
+// Package trace records the calls an operation made, its retries and the node which
+// answered it. A trace is opted into by the context of the operation.
+package trace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hop is a call of a traced operation
+type Hop struct {
+	Addr    string
+	Method  string
+	Latency time.Duration
+	Err     string // empty if the call succeeded
+}
+
+// Trace is the record of an operation. Calls left running in the background
+// once the operation returned are not recorded
+type Trace struct {
+	Op, Key string
+
+	lock    sync.Mutex
+	hops    []Hop
+	retries int
+	owner   string
+}
+
+type traceKey struct{}
+
+// function New() returns a context which traces the operation op of key, and its trace
+func New(ctx context.Context, op, key string) (context.Context, *Trace) {
+	t := &Trace{Op: op, Key: key}
+	return context.WithValue(ctx, traceKey{}, t), t
+}
+
+// function From() returns the trace of ctx, nil if it is not traced
+func From(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// method Add() records a call which took latency and returned err.
+// Like the other methods it does nothing on a nil trace
+func (t *Trace) Add(addr, method string, latency time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	h := Hop{Addr: addr, Method: method, Latency: latency}
+	if err != nil {
+		h.Err = err.Error()
+	}
+	t.lock.Lock()
+	t.hops = append(t.hops, h)
+	t.lock.Unlock()
+}
+
+// method Retry() counts a retry of the operation or of one of its steps
+func (t *Trace) Retry() {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	t.retries++
+	t.lock.Unlock()
+}
+
+// method SetOwner() records the node responsible for the key
+func (t *Trace) SetOwner(addr string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	t.owner = addr
+	t.lock.Unlock()
+}
+
+// method Hops() returns the calls recorded, in order
+func (t *Trace) Hops() []Hop {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]Hop(nil), t.hops...)
+}
+
+// method Retries() returns the number of retries
+func (t *Trace) Retries() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.retries
+}
+
+// method Owner() returns the node responsible for the key, empty if none was found
+func (t *Trace) Owner() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.owner
+}
+
+// method String() renders the trace, one call per line
+func (t *Trace) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var b strings.Builder
+	owner := t.owner
+	if owner == "" {
+		owner = "none"
+	}
+	var total time.Duration
+	for _, h := range t.hops {
+		total += h.Latency
+	}
+	fmt.Fprintf(&b, "trace %s %s: owner %s, %d calls in %v, %d retries\n", t.Op, t.Key, owner, len(t.hops), total, t.retries)
+	for i, h := range t.hops {
+		fmt.Fprintf(&b, "%3d. %-24s %-32s %v", i+1, h.Addr, h.Method, h.Latency)
+		if h.Err != "" {
+			fmt.Fprintf(&b, "  error: %s", h.Err)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}